@@ -0,0 +1,39 @@
+// Package assets serves the templates, static files and migrations, either
+// from the copies embedded in the binary or, for live editing, from a
+// source tree on disk.
+package assets
+
+import (
+	"io/fs"
+	"os"
+)
+
+// FS is rooted at the top of the source tree: it contains templates/,
+// static/ and database/migrations/.
+var FS fs.FS
+
+// Use selects where assets are read from: dir on disk if it is set,
+// otherwise the embedded copies.
+func Use(embedded fs.FS, dir string) {
+	if dir != "" {
+		FS = os.DirFS(dir)
+		return
+	}
+	FS = embedded
+}
+
+// Static returns the static file tree served under /static/.
+func Static() fs.FS {
+	return sub("static")
+}
+
+// Migrations returns the directory of SQL migrations.
+func Migrations() fs.FS {
+	return sub("database/migrations")
+}
+
+func sub(dir string) fs.FS {
+	// fs.Sub only fails for invalid paths, and dir is always a valid one.
+	sub, _ := fs.Sub(FS, dir)
+	return sub
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"silic0n-wiki/assets"
+	"silic0n-wiki/auth"
+	"silic0n-wiki/config"
+	"silic0n-wiki/database"
+	"silic0n-wiki/models"
+	"silic0n-wiki/routes"
+	"silic0n-wiki/search"
+)
+
+// errUsage makes main print the usage text and exit.
+var errUsage = errors.New("usage")
+
+func runServe() error {
+	if err := database.RunMigrations(assets.Migrations()); err != nil {
+		return fmt.Errorf("failed to run migrations: %w", err)
+	}
+
+	if err := models.EnsureArticleLinks(); err != nil {
+		return fmt.Errorf("failed to build article link table: %w", err)
+	}
+
+	if err := models.EnsureArticleMedia(); err != nil {
+		return fmt.Errorf("failed to build article media table: %w", err)
+	}
+
+	if err := search.Open(); err != nil {
+		return fmt.Errorf("failed to open search index: %w", err)
+	}
+	defer search.Close()
+
+	if err := models.EnsureSearchIndex(); err != nil {
+		return fmt.Errorf("failed to build search index: %w", err)
+	}
+
+	if err := os.MkdirAll(config.AppConfig.Media.UploadDir, 0755); err != nil {
+		return fmt.Errorf("failed to create upload directory: %w", err)
+	}
+
+	if err := models.EnsureMediaDimensions(config.AppConfig.Media.UploadDir); err != nil {
+		return fmt.Errorf("failed to measure uploaded images: %w", err)
+	}
+
+	log.Printf("Server starting on port %d", config.AppConfig.Server.Port)
+	routes.StartRouter()
+	return nil
+}
+
+func runMigrate(args []string) error {
+	command := "up"
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "up":
+		if len(args) != 0 {
+			return errUsage
+		}
+		if err := database.RunMigrations(assets.Migrations()); err != nil {
+			return fmt.Errorf("failed to run migrations: %w", err)
+		}
+		return nil
+	case "down":
+		steps := 1
+		if len(args) == 1 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 1 {
+				return errUsage
+			}
+			steps = n
+		} else if len(args) > 1 {
+			return errUsage
+		}
+		return database.RollbackMigrations(assets.Migrations(), steps)
+	case "status":
+		if len(args) != 0 {
+			return errUsage
+		}
+		return printMigrationStatus()
+	}
+	return errUsage
+}
+
+func printMigrationStatus() error {
+	statuses, err := database.MigrationStatuses(assets.Migrations())
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tSTATUS\tAPPLIED AT\tDOWN")
+	for _, s := range statuses {
+		state, appliedAt := "pending", ""
+		if s.Applied {
+			state = "applied"
+			appliedAt = s.AppliedAt.Local().Format("2006-01-02 15:04:05")
+		}
+		switch {
+		case s.Missing:
+			state = "applied, file missing"
+		case s.Modified:
+			state = "applied, file modified"
+		}
+		down := "no"
+		if s.HasDown {
+			down = "yes"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.Version, state, appliedAt, down)
+	}
+	return w.Flush()
+}
+
+func runReindex() error {
+	if err := search.Open(); err != nil {
+		return fmt.Errorf("failed to open search index: %w", err)
+	}
+	defer search.Close()
+
+	n, err := models.ReindexSearch()
+	if err != nil {
+		return fmt.Errorf("failed to rebuild search index: %w", err)
+	}
+	fmt.Printf("Reindexed %d articles\n", n)
+	return nil
+}
+
+func runUser(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	command, args := args[0], args[1:]
+
+	switch command {
+	case "create":
+		return runUserCreate(args)
+	case "set-role":
+		return runUserSetRole(args)
+	case "reset-password":
+		return runUserResetPassword(args)
+	case "disable":
+		return runUserSetDisabled(args, true)
+	case "enable":
+		return runUserSetDisabled(args, false)
+	}
+	return errUsage
+}
+
+func runUserCreate(args []string) error {
+	fs := flag.NewFlagSet("user create", flag.ExitOnError)
+	role := fs.String("role", string(models.RoleEditor), "role of the new account")
+	passwordStdin := fs.Bool("password-stdin", false, "read the password from standard input")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return errUsage
+	}
+	username, email := fs.Arg(0), fs.Arg(1)
+
+	if err := models.ValidateUsername(username); err != nil {
+		return err
+	}
+	if err := models.ValidateEmail(email); err != nil {
+		return err
+	}
+	if !models.Role(*role).Valid() {
+		return fmt.Errorf("unknown role %q", *role)
+	}
+	if _, err := models.GetUserByUsername(username); err == nil {
+		return fmt.Errorf("username %q is already taken", username)
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+	if _, err := models.GetUserByEmail(email); err == nil {
+		return fmt.Errorf("email %q is already registered", email)
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+
+	password, err := readPassword(*passwordStdin)
+	if err != nil {
+		return err
+	}
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	user, err := models.CreateUserWithRole(username, email, hash, models.Role(*role))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created %s %s (id %d)\n", user.Role, user.Username, user.ID)
+	return nil
+}
+
+func runUserSetRole(args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	role := models.Role(args[1])
+	if !role.Valid() {
+		return fmt.Errorf("unknown role %q", args[1])
+	}
+
+	user, err := lookupUser(args[0])
+	if err != nil {
+		return err
+	}
+	if err := models.SetUserRole(user.ID, role); err != nil {
+		return err
+	}
+	fmt.Printf("%s is now %s\n", user.Username, role)
+	return nil
+}
+
+func runUserResetPassword(args []string) error {
+	fs := flag.NewFlagSet("user reset-password", flag.ExitOnError)
+	passwordStdin := fs.Bool("password-stdin", false, "read the password from standard input")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+
+	user, err := lookupUser(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	password, err := readPassword(*passwordStdin)
+	if err != nil {
+		return err
+	}
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	if err := models.SetUserPassword(user.ID, hash); err != nil {
+		return err
+	}
+	if err := models.DeleteUserSessions(user.ID); err != nil {
+		return err
+	}
+	fmt.Printf("Password reset for %s\n", user.Username)
+	return nil
+}
+
+func runUserSetDisabled(args []string, disabled bool) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	user, err := lookupUser(args[0])
+	if err != nil {
+		return err
+	}
+	if err := models.SetUserDisabled(user.ID, disabled); err != nil {
+		return err
+	}
+	if disabled {
+		fmt.Printf("Disabled %s\n", user.Username)
+	} else {
+		fmt.Printf("Enabled %s\n", user.Username)
+	}
+	return nil
+}
+
+func runSessions(args []string) error {
+	if len(args) != 1 || args[0] != "purge" {
+		return errUsage
+	}
+
+	n, err := models.DeleteExpiredSessions()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Deleted %d expired sessions\n", n)
+	return nil
+}
+
+func runMedia(args []string) error {
+	if len(args) != 1 || args[0] != "migrate" {
+		return errUsage
+	}
+
+	media := config.AppConfig.Media
+	moved, shared, err := models.MigrateMediaStorage(media.UploadDir, media.ThumbDir())
+	fmt.Printf("Moved %d files, merged %d duplicates\n", moved, shared)
+	return err
+}
+
+func lookupUser(username string) (*models.User, error) {
+	user, err := models.GetUserByUsername(username)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("no user named %q", username)
+	}
+	return user, err
+}
+
+// readPassword reads a password from the first line of standard input, or
+// generates and prints a random one.
+func readPassword(fromStdin bool) (string, error) {
+	if !fromStdin {
+		password, err := auth.GenerateToken(12)
+		if err != nil {
+			return "", err
+		}
+		fmt.Printf("Generated password: %s\n", password)
+		return password, nil
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	password := strings.TrimRight(line, "\r\n")
+	if err := models.ValidatePassword(password); err != nil {
+		return "", err
+	}
+	return password, nil
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"path/filepath"
 
 	"gopkg.in/yaml.v3"
 )
@@ -11,12 +12,38 @@ type Config struct {
 	Server   ServerConfig   `yaml:"server"`
 	Secret   string         `yaml:"secret"`
 	Media    MediaConfig    `yaml:"media"`
+	Search   SearchConfig   `yaml:"search"`
 }
 
 type MediaConfig struct {
 	UploadDir    string   `yaml:"upload_dir"`
 	MaxFileSize  int64    `yaml:"max_file_size"`
 	AllowedTypes []string `yaml:"allowed_types"`
+	// MaxPixels caps width × height of uploaded images, which are decoded
+	// in full to strip metadata and resize them. A small file can declare
+	// an enormous image, so the file size limit alone does not bound memory.
+	MaxPixels int64 `yaml:"max_pixels"`
+}
+
+// DefaultMaxPixels is used when media.max_pixels is not set.
+const DefaultMaxPixels = 50_000_000
+
+// PixelLimit returns MaxPixels, or DefaultMaxPixels if it is not set.
+func (m MediaConfig) PixelLimit() int64 {
+	if m.MaxPixels > 0 {
+		return m.MaxPixels
+	}
+	return DefaultMaxPixels
+}
+
+// ThumbDir is where resized image variants are cached.
+func (m MediaConfig) ThumbDir() string {
+	return filepath.Join(m.UploadDir, "thumbs")
+}
+
+type SearchConfig struct {
+	Backend   string `yaml:"backend"`    // "postgres" (default) or "embedded"
+	IndexPath string `yaml:"index_path"` // index file for the embedded backend
 }
 
 type DatabaseConfig struct {
@@ -30,6 +57,9 @@ type DatabaseConfig struct {
 
 type ServerConfig struct {
 	Port int `yaml:"port"`
+	// AssetsDir serves templates, static files and migrations from this
+	// directory instead of the copies embedded in the binary.
+	AssetsDir string `yaml:"assets_dir"`
 }
 
 var AppConfig *Config
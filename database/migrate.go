@@ -1,40 +1,290 @@
 package database
 
 import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"fmt"
-	"os"
-	"path/filepath"
+	"io/fs"
 	"sort"
+	"strings"
+	"time"
 )
 
-func RunMigrations(migrationsPath string) error {
-	files, err := os.ReadDir(migrationsPath)
+// migrationLockID is the Postgres advisory lock key held while migrating,
+// so instances starting at the same time apply each migration only once.
+const migrationLockID = 0x5111c0de
+
+// Migration is one NNN_name.sql file and its optional NNN_name.down.sql
+// counterpart. The version is the file name without the extension.
+type Migration struct {
+	Version  string
+	Up       string
+	Down     string
+	HasDown  bool
+	Checksum string
+}
+
+// MigrationStatus describes a migration found on disk, in the
+// schema_migrations table, or both.
+type MigrationStatus struct {
+	Version   string
+	Applied   bool
+	AppliedAt time.Time
+	// Modified is set when the file changed after it was applied.
+	Modified bool
+	// Missing is set when an applied migration has no file any more.
+	Missing bool
+	HasDown bool
+}
+
+// LoadMigrations reads the migrations in fsys, oldest first.
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
 	if err != nil {
-		return fmt.Errorf("failed to read migrations directory: %w", err)
+		return nil, fmt.Errorf("failed to read migrations directory: %w", err)
 	}
 
-	var sqlFiles []string
-	for _, f := range files {
-		if filepath.Ext(f.Name()) == ".sql" {
-			sqlFiles = append(sqlFiles, f.Name())
+	byVersion := make(map[string]*Migration)
+	var versions []string
+	downs := make(map[string]string)
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".sql") {
+			continue
+		}
+		content, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", name, err)
+		}
+
+		if version, ok := strings.CutSuffix(name, ".down.sql"); ok {
+			downs[version] = string(content)
+			continue
 		}
+		version := strings.TrimSuffix(name, ".sql")
+		sum := sha256.Sum256(content)
+		byVersion[version] = &Migration{
+			Version:  version,
+			Up:       string(content),
+			Checksum: hex.EncodeToString(sum[:]),
+		}
+		versions = append(versions, version)
+	}
+
+	for version := range downs {
+		if byVersion[version] == nil {
+			return nil, fmt.Errorf("down migration %s.down.sql has no matching up migration", version)
+		}
+	}
+
+	sort.Strings(versions)
+	migrations := make([]Migration, 0, len(versions))
+	for _, version := range versions {
+		m := byVersion[version]
+		m.Down, m.HasDown = downs[version]
+		migrations = append(migrations, *m)
+	}
+	return migrations, nil
+}
+
+type appliedMigration struct {
+	checksum  string
+	appliedAt time.Time
+}
+
+// RunMigrations applies every pending migration in fsys. It refuses to run
+// if an applied migration's file has been edited since, as the database
+// would no longer match the files.
+func RunMigrations(fsys fs.FS) error {
+	migrations, err := LoadMigrations(fsys)
+	if err != nil {
+		return err
 	}
-	sort.Strings(sqlFiles)
 
-	for _, file := range sqlFiles {
-		path := filepath.Join(migrationsPath, file)
-		content, err := os.ReadFile(path)
+	return withMigrationLock(func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(conn)
 		if err != nil {
-			return fmt.Errorf("failed to read migration %s: %w", file, err)
+			return err
 		}
 
-		_, err = DB.Exec(string(content))
+		var modified []string
+		for _, m := range migrations {
+			if a, ok := applied[m.Version]; ok && a.checksum != m.Checksum {
+				modified = append(modified, m.Version)
+			}
+		}
+		if len(modified) > 0 {
+			return fmt.Errorf("applied migrations were modified since they ran: %s; restore the original files and add a new migration instead",
+				strings.Join(modified, ", "))
+		}
+
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			err := inMigrationTx(conn, m.Up, func(tx *sql.Tx) error {
+				_, err := tx.Exec(
+					`INSERT INTO schema_migrations (version, checksum) VALUES ($1, $2)`,
+					m.Version, m.Checksum,
+				)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("failed to execute migration %s: %w", m.Version, err)
+			}
+			fmt.Printf("Applied migration: %s\n", m.Version)
+		}
+		return nil
+	})
+}
+
+// RollbackMigrations reverts the last steps applied migrations using their
+// down scripts. It stops at the first migration without one.
+func RollbackMigrations(fsys fs.FS, steps int) error {
+	migrations, err := LoadMigrations(fsys)
+	if err != nil {
+		return err
+	}
+	byVersion := make(map[string]Migration, len(migrations))
+	for _, m := range migrations {
+		byVersion[m.Version] = m
+	}
+
+	return withMigrationLock(func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(conn)
 		if err != nil {
-			return fmt.Errorf("failed to execute migration %s: %w", file, err)
+			return err
+		}
+		versions := make([]string, 0, len(applied))
+		for version := range applied {
+			versions = append(versions, version)
 		}
+		sort.Sort(sort.Reverse(sort.StringSlice(versions)))
 
-		fmt.Printf("Applied migration: %s\n", file)
+		for i := 0; i < steps && i < len(versions); i++ {
+			m, ok := byVersion[versions[i]]
+			if !ok {
+				return fmt.Errorf("cannot roll back %s: its migration file is missing", versions[i])
+			}
+			if !m.HasDown {
+				return fmt.Errorf("cannot roll back %s: it has no %s.down.sql", m.Version, m.Version)
+			}
+			err := inMigrationTx(conn, m.Down, func(tx *sql.Tx) error {
+				_, err := tx.Exec(`DELETE FROM schema_migrations WHERE version = $1`, m.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("failed to roll back migration %s: %w", m.Version, err)
+			}
+			fmt.Printf("Rolled back migration: %s\n", m.Version)
+		}
+		return nil
+	})
+}
+
+// MigrationStatuses compares the migrations in fsys with those applied.
+func MigrationStatuses(fsys fs.FS) ([]MigrationStatus, error) {
+	migrations, err := LoadMigrations(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	err = withMigrationLock(func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			s := MigrationStatus{Version: m.Version, HasDown: m.HasDown}
+			if a, ok := applied[m.Version]; ok {
+				s.Applied = true
+				s.AppliedAt = a.appliedAt
+				s.Modified = a.checksum != m.Checksum
+				delete(applied, m.Version)
+			}
+			statuses = append(statuses, s)
+		}
+		for version, a := range applied {
+			statuses = append(statuses, MigrationStatus{
+				Version: version, Applied: true, AppliedAt: a.appliedAt, Missing: true,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// withMigrationLock runs fn on a single connection holding the migration
+// advisory lock, creating the schema_migrations table first if needed.
+func withMigrationLock(fn func(conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
 	}
+	defer conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, migrationLockID)
 
-	return nil
+	_, err = conn.ExecContext(ctx,
+		`CREATE TABLE IF NOT EXISTS schema_migrations (
+			version VARCHAR(255) PRIMARY KEY,
+			checksum CHAR(64) NOT NULL,
+			applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	return fn(conn)
+}
+
+func appliedMigrations(conn *sql.Conn) (map[string]appliedMigration, error) {
+	rows, err := conn.QueryContext(context.Background(),
+		`SELECT version, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[string]appliedMigration)
+	for rows.Next() {
+		var version string
+		var a appliedMigration
+		if err := rows.Scan(&version, &a.checksum, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = a
+	}
+	return applied, rows.Err()
+}
+
+// inMigrationTx runs a migration script and the bookkeeping in record in
+// one transaction, so a failing script leaves no trace.
+func inMigrationTx(conn *sql.Conn, script string, record func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(context.Background(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if _, err := tx.Exec(script); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := record(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
 }
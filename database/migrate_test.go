@@ -0,0 +1,108 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func checksum(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"002_add_index.sql":          {Data: []byte("CREATE INDEX b;")},
+		"001_create_table.sql":       {Data: []byte("CREATE TABLE a;")},
+		"001_create_table.down.sql":  {Data: []byte("DROP TABLE a;")},
+		"010_seed.sql":               {Data: []byte("INSERT INTO a;")},
+		"README.md":                  {Data: []byte("not a migration")},
+		"old/003_ignored.sql":        {Data: []byte("ignored")},
+		"002_add_index.down.sql.bak": {Data: []byte("ignored")},
+	}
+	got, err := LoadMigrations(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Migration{
+		{Version: "001_create_table", Up: "CREATE TABLE a;", Down: "DROP TABLE a;", HasDown: true, Checksum: checksum("CREATE TABLE a;")},
+		{Version: "002_add_index", Up: "CREATE INDEX b;", Checksum: checksum("CREATE INDEX b;")},
+		{Version: "010_seed", Up: "INSERT INTO a;", Checksum: checksum("INSERT INTO a;")},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d migrations, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("migration %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestLoadMigrationsChecksumIgnoresDown(t *testing.T) {
+	up := []byte("CREATE TABLE a;")
+	without, err := LoadMigrations(fstest.MapFS{"001_a.sql": {Data: up}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	with, err := LoadMigrations(fstest.MapFS{
+		"001_a.sql":      {Data: up},
+		"001_a.down.sql": {Data: []byte("DROP TABLE a;")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if without[0].Checksum != with[0].Checksum {
+		t.Error("adding a down script changed the checksum of the up script")
+	}
+
+	edited, err := LoadMigrations(fstest.MapFS{"001_a.sql": {Data: []byte("CREATE TABLE a ();")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edited[0].Checksum == without[0].Checksum {
+		t.Error("editing the up script kept its checksum")
+	}
+}
+
+func TestLoadMigrationsOrphanDown(t *testing.T) {
+	_, err := LoadMigrations(fstest.MapFS{
+		"001_a.sql":      {Data: []byte("CREATE TABLE a;")},
+		"002_b.down.sql": {Data: []byte("DROP TABLE b;")},
+	})
+	if err == nil || !strings.Contains(err.Error(), "002_b.down.sql") {
+		t.Errorf("err = %v, want an error naming 002_b.down.sql", err)
+	}
+}
+
+func TestLoadMigrationsEmpty(t *testing.T) {
+	got, err := LoadMigrations(fstest.MapFS{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("got %d migrations from an empty directory", len(got))
+	}
+}
+
+// The shipped migrations must load, and every down script must belong to
+// an up script.
+func TestLoadMigrationsShipped(t *testing.T) {
+	got, err := LoadMigrations(os.DirFS("migrations"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) == 0 {
+		t.Fatal("no migrations found")
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i-1].Version[:3] == got[i].Version[:3] {
+			t.Errorf("migrations %s and %s share a number", got[i-1].Version, got[i].Version)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS article_revisions (
+    id SERIAL PRIMARY KEY,
+    article_id INTEGER NOT NULL REFERENCES articles(id) ON DELETE CASCADE,
+    title VARCHAR(255) NOT NULL,
+    content TEXT NOT NULL,
+    category_id INTEGER REFERENCES categories(id) ON DELETE SET NULL,
+    tags TEXT[] NOT NULL DEFAULT '{}',
+    edited_by VARCHAR(255) NOT NULL,
+    summary VARCHAR(500) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_article_revisions_article ON article_revisions(article_id, id DESC);
+
+-- Snapshot articles that existed before revisions were tracked
+INSERT INTO article_revisions (article_id, title, content, category_id, tags, edited_by, summary, created_at)
+SELECT a.id, a.title, a.content, a.category_id,
+       ARRAY(SELECT t.name FROM article_tags at JOIN tags t ON t.id = at.tag_id
+             WHERE at.article_id = a.id ORDER BY t.name),
+       COALESCE(a.last_edited_by, 'system'), 'Imported existing content', a.updated_at
+FROM articles a
+WHERE NOT EXISTS (SELECT 1 FROM article_revisions r WHERE r.article_id = a.id);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS slug_aliases (
+    slug VARCHAR(255) PRIMARY KEY,
+    article_id INTEGER NOT NULL REFERENCES articles(id) ON DELETE CASCADE,
+    created_by VARCHAR(255) NOT NULL DEFAULT 'system',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_slug_aliases_article ON slug_aliases(article_id);
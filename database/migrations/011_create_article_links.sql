@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS article_links (
+    article_id INTEGER NOT NULL REFERENCES articles(id) ON DELETE CASCADE,
+    target_slug VARCHAR(255) NOT NULL,
+    PRIMARY KEY (article_id, target_slug)
+);
+
+CREATE INDEX IF NOT EXISTS idx_article_links_target ON article_links(target_slug);
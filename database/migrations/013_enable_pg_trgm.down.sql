@@ -0,0 +1,3 @@
+-- The extension itself is left installed; other database objects may use it.
+DROP INDEX IF EXISTS idx_articles_title_trgm;
+DROP INDEX IF EXISTS idx_articles_slug_trgm;
@@ -0,0 +1,5 @@
+-- Trigram indexes for typo-tolerant title and slug matching.
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS idx_articles_title_trgm ON articles USING gin(title gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_articles_slug_trgm ON articles USING gin(slug gin_trgm_ops);
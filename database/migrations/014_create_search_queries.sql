@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS search_queries (
+    id SERIAL PRIMARY KEY,
+    query VARCHAR(255) NOT NULL,
+    source VARCHAR(20) NOT NULL,
+    result_count INTEGER NOT NULL,
+    clicked_slug VARCHAR(255),
+    clicked_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_search_queries_created_at ON search_queries(created_at);
+CREATE INDEX IF NOT EXISTS idx_search_queries_zero ON search_queries(query) WHERE result_count = 0;
@@ -0,0 +1,14 @@
+-- Existing users keep the editing rights they had before roles existed.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'editor';
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'users_role_check') THEN
+        ALTER TABLE users ADD CONSTRAINT users_role_check
+            CHECK (role IN ('reader', 'editor', 'moderator', 'admin'));
+    END IF;
+END $$;
+
+-- Nobody is promoted here: on a public wiki the oldest account may belong to
+-- anyone. Create the first admin with `silic0n-wiki user create -role admin`
+-- or promote an account with `silic0n-wiki user set-role USERNAME admin`.
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS protection_log;
+ALTER TABLE articles DROP COLUMN IF EXISTS protection_expires_at;
+ALTER TABLE articles DROP COLUMN IF EXISTS protection;
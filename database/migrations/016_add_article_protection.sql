@@ -0,0 +1,14 @@
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS protection VARCHAR(20) NOT NULL DEFAULT 'none';
+ALTER TABLE articles ADD COLUMN IF NOT EXISTS protection_expires_at TIMESTAMP WITH TIME ZONE;
+
+CREATE TABLE IF NOT EXISTS protection_log (
+    id SERIAL PRIMARY KEY,
+    article_id INTEGER NOT NULL REFERENCES articles(id) ON DELETE CASCADE,
+    protection VARCHAR(20) NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    changed_by VARCHAR(50) NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_protection_log_article ON protection_log(article_id, created_at DESC);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_articles_deleted_at;
+ALTER TABLE articles DROP COLUMN IF EXISTS delete_reason;
+ALTER TABLE articles DROP COLUMN IF EXISTS deleted_by;
+ALTER TABLE articles DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_media_original_name_trgm;
+DROP TABLE IF EXISTS article_media;
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS article_media (
+    article_id INTEGER NOT NULL REFERENCES articles(id) ON DELETE CASCADE,
+    media_id INTEGER NOT NULL REFERENCES media(id) ON DELETE CASCADE,
+    PRIMARY KEY (article_id, media_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_article_media_media ON article_media(media_id);
+CREATE INDEX IF NOT EXISTS idx_media_original_name_trgm ON media USING gin(original_name gin_trgm_ops);
@@ -0,0 +1,4 @@
+-- Pixel size of uploaded images, for responsive rendering. NULL until
+-- measured; 0 when the file could not be measured.
+ALTER TABLE media ADD COLUMN IF NOT EXISTS width INTEGER;
+ALTER TABLE media ADD COLUMN IF NOT EXISTS height INTEGER;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_media_sha256;
+ALTER TABLE media DROP COLUMN IF EXISTS sha256;
@@ -0,0 +1,6 @@
+-- Content hash of each stored file. Files uploaded before this column
+-- existed have none until `silic0n-wiki media migrate` moves them into the
+-- content-addressed layout.
+ALTER TABLE media ADD COLUMN IF NOT EXISTS sha256 CHAR(64);
+
+CREATE INDEX IF NOT EXISTS idx_media_sha256 ON media(sha256);
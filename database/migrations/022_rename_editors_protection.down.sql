@@ -0,0 +1,2 @@
+UPDATE articles SET protection = 'editors' WHERE protection = 'moderators';
+UPDATE protection_log SET protection = 'editors' WHERE protection = 'moderators';
@@ -0,0 +1,4 @@
+-- The "editors" level let every editor through, so it protected nothing.
+-- It now requires a moderator and is named after the role it admits.
+UPDATE articles SET protection = 'moderators' WHERE protection = 'editors';
+UPDATE protection_log SET protection = 'moderators' WHERE protection = 'editors';
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_articles_live_slug;
+ALTER TABLE articles ADD CONSTRAINT articles_slug_key UNIQUE (slug);
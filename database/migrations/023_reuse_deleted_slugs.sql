@@ -0,0 +1,4 @@
+-- A page in the trash no longer holds on to its slug: only live articles
+-- must have unique slugs.
+ALTER TABLE articles DROP CONSTRAINT IF EXISTS articles_slug_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_articles_live_slug ON articles(slug) WHERE deleted_at IS NULL;
@@ -0,0 +1,13 @@
+-- article_id has no foreign key so entries outlive purged articles.
+CREATE TABLE IF NOT EXISTS deletion_log (
+    id SERIAL PRIMARY KEY,
+    article_id INTEGER NOT NULL,
+    slug VARCHAR(255) NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    action VARCHAR(20) NOT NULL,
+    performed_by VARCHAR(50) NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_deletion_log_created_at ON deletion_log(created_at DESC);
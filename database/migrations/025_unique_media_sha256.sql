@@ -0,0 +1,24 @@
+-- Each stored file has one record of its own, named after its hash, so a
+-- concurrent upload of the same file cannot add a second one. Records of
+-- files uploaded before content-addressed storage keep their own names and
+-- may share a hash.
+UPDATE media m SET article_id = d.article_id
+FROM media d
+WHERE d.sha256 = m.sha256 AND d.filename = m.filename AND d.id > m.id
+  AND m.article_id IS NULL AND d.article_id IS NOT NULL
+  AND left(m.filename, 64) = m.sha256;
+
+INSERT INTO article_media (article_id, media_id)
+SELECT am.article_id, m.id
+FROM article_media am
+JOIN media d ON d.id = am.media_id
+JOIN media m ON m.sha256 = d.sha256 AND m.filename = d.filename AND m.id < d.id
+WHERE left(d.filename, 64) = d.sha256
+ON CONFLICT DO NOTHING;
+
+DELETE FROM media d
+USING media m
+WHERE d.sha256 = m.sha256 AND d.filename = m.filename AND d.id > m.id
+  AND left(d.filename, 64) = d.sha256;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_media_stored_sha256 ON media(sha256) WHERE left(filename, 64) = sha256;
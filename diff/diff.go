@@ -0,0 +1,413 @@
+// Package diff computes line- and word-level differences between two texts.
+// It has no dependencies on the rest of the wiki so it can be used from
+// handlers, models and background jobs alike.
+package diff
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Op describes how a piece of text changed between the old and new version.
+type Op int
+
+const (
+	Equal Op = iota
+	Delete
+	Insert
+)
+
+func (o Op) String() string {
+	switch o {
+	case Delete:
+		return "delete"
+	case Insert:
+		return "insert"
+	default:
+		return "equal"
+	}
+}
+
+// Segment is a run of text sharing the same Op.
+type Segment struct {
+	Op   Op
+	Text string
+}
+
+// Line is a single line of a line-level diff. OldNum and NewNum are 1-based
+// line numbers and are zero when the line does not exist on that side.
+// Segments holds a word-level diff for lines that were modified in place.
+type Line struct {
+	Op       Op
+	OldNum   int
+	NewNum   int
+	Text     string
+	Segments []Segment
+}
+
+// Hunk is a group of changed lines surrounded by unchanged context.
+type Hunk struct {
+	OldStart int
+	NewStart int
+	Lines    []Line
+}
+
+// SplitLines normalises line endings and splits text into lines.
+func SplitLines(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+// Lines returns a full line-level diff of a and b. Runs of deleted lines that
+// are directly followed by inserted lines are paired up and given word-level
+// segments so callers can highlight the exact change.
+func Lines(a, b string) []Line {
+	oldLines := SplitLines(a)
+	newLines := SplitLines(b)
+	ops := script(oldLines, newLines)
+
+	var lines []Line
+	oldNum, newNum := 0, 0
+	for _, op := range ops {
+		switch op {
+		case Equal:
+			oldNum++
+			newNum++
+			lines = append(lines, Line{Op: Equal, OldNum: oldNum, NewNum: newNum, Text: oldLines[oldNum-1]})
+		case Delete:
+			oldNum++
+			lines = append(lines, Line{Op: Delete, OldNum: oldNum, Text: oldLines[oldNum-1]})
+		case Insert:
+			newNum++
+			lines = append(lines, Line{Op: Insert, NewNum: newNum, Text: newLines[newNum-1]})
+		}
+	}
+
+	pairChangedLines(lines)
+	return lines
+}
+
+func pairChangedLines(lines []Line) {
+	for i := 0; i < len(lines); {
+		if lines[i].Op != Delete {
+			i++
+			continue
+		}
+		delStart := i
+		for i < len(lines) && lines[i].Op == Delete {
+			i++
+		}
+		insStart := i
+		for i < len(lines) && lines[i].Op == Insert {
+			i++
+		}
+		dels := insStart - delStart
+		ins := i - insStart
+		for j := 0; j < dels && j < ins; j++ {
+			oldLine := &lines[delStart+j]
+			newLine := &lines[insStart+j]
+			segments := Words(oldLine.Text, newLine.Text)
+			oldLine.Segments = filterSegments(segments, Insert)
+			newLine.Segments = filterSegments(segments, Delete)
+		}
+	}
+}
+
+func filterSegments(segments []Segment, drop Op) []Segment {
+	var out []Segment
+	for _, s := range segments {
+		if s.Op != drop {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// Words returns a word-level diff of a and b. Whitespace and punctuation are
+// treated as separate tokens so edits inside a sentence stay small.
+func Words(a, b string) []Segment {
+	oldTokens := tokenize(a)
+	newTokens := tokenize(b)
+	ops := script(oldTokens, newTokens)
+
+	var segments []Segment
+	i, j := 0, 0
+	for _, op := range ops {
+		var text string
+		switch op {
+		case Equal:
+			text = oldTokens[i]
+			i++
+			j++
+		case Delete:
+			text = oldTokens[i]
+			i++
+		case Insert:
+			text = newTokens[j]
+			j++
+		}
+		if n := len(segments); n > 0 && segments[n-1].Op == op {
+			segments[n-1].Text += text
+		} else {
+			segments = append(segments, Segment{Op: op, Text: text})
+		}
+	}
+	return segments
+}
+
+func tokenize(s string) []string {
+	var tokens []string
+	start := -1
+	kind := 0
+	for i, r := range s {
+		k := tokenKind(r)
+		if start >= 0 && (k != kind || k == 0) {
+			tokens = append(tokens, s[start:i])
+			start = -1
+		}
+		if start < 0 {
+			start = i
+			kind = k
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, s[start:])
+	}
+	return tokens
+}
+
+// tokenKind groups letters and digits into words and whitespace into runs;
+// every other rune is its own token (kind 0).
+func tokenKind(r rune) int {
+	switch {
+	case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_':
+		return 1
+	case unicode.IsSpace(r):
+		return 2
+	default:
+		return 0
+	}
+}
+
+// Hunks groups a line diff into hunks, keeping up to context unchanged lines
+// around each change. A diff with no changes yields no hunks.
+func Hunks(lines []Line, context int) []Hunk {
+	var hunks []Hunk
+	var current *Hunk
+	lastChange := -1
+
+	for i, line := range lines {
+		if line.Op == Equal {
+			continue
+		}
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		if current != nil && start <= lastChange+context+1 {
+			current.Lines = append(current.Lines, lines[lastChange+1:i+1]...)
+		} else {
+			if current != nil {
+				current.Lines = append(current.Lines, trailingContext(lines, lastChange, context)...)
+				hunks = append(hunks, *current)
+			}
+			current = &Hunk{Lines: append([]Line(nil), lines[start:i+1]...)}
+			current.OldStart, current.NewStart = hunkStart(lines, start)
+		}
+		lastChange = i
+	}
+	if current != nil {
+		current.Lines = append(current.Lines, trailingContext(lines, lastChange, context)...)
+		hunks = append(hunks, *current)
+	}
+	return hunks
+}
+
+func trailingContext(lines []Line, lastChange, context int) []Line {
+	end := lastChange + 1 + context
+	if end > len(lines) {
+		end = len(lines)
+	}
+	return lines[lastChange+1 : end]
+}
+
+// hunkStart returns the first old and new line numbers covered from index i.
+func hunkStart(lines []Line, i int) (int, int) {
+	oldStart, newStart := 1, 1
+	for _, l := range lines[:i] {
+		if l.OldNum > 0 {
+			oldStart = l.OldNum + 1
+		}
+		if l.NewNum > 0 {
+			newStart = l.NewNum + 1
+		}
+	}
+	return oldStart, newStart
+}
+
+// script returns the shortest edit script that turns a into b using Myers'
+// algorithm. Each Equal consumes one element of both inputs, each Delete one
+// element of a and each Insert one element of b. Within a run of changes
+// deletions come before insertions.
+func script(a, b []string) []Op {
+	ops := appendScript(make([]Op, 0, len(a)+len(b)), a, b)
+	for i := 0; i < len(ops); {
+		if ops[i] == Equal {
+			i++
+			continue
+		}
+		start, dels := i, 0
+		for ; i < len(ops) && ops[i] != Equal; i++ {
+			if ops[i] == Delete {
+				dels++
+			}
+		}
+		for j := start; j < i; j++ {
+			if j < start+dels {
+				ops[j] = Delete
+			} else {
+				ops[j] = Insert
+			}
+		}
+	}
+	return ops
+}
+
+// appendScript appends the edit script for a and b to ops. It uses the
+// linear-space variant of Myers' algorithm: the middle snake of the shortest
+// path splits the problem in two, so memory stays proportional to the input
+// rather than to the square of the edit distance.
+func appendScript(ops []Op, a, b []string) []Op {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	ops = appendOps(ops, Equal, prefix)
+	a, b = a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	switch {
+	case len(a) == 0:
+		ops = appendOps(ops, Insert, len(b))
+	case len(b) == 0:
+		ops = appendOps(ops, Delete, len(a))
+	default:
+		if x, y, ok := middleSnake(a, b); ok {
+			ops = appendScript(ops, a[:x], b[:y])
+			ops = appendScript(ops, a[x:], b[y:])
+		} else {
+			ops = appendOps(ops, Delete, len(a))
+			ops = appendOps(ops, Insert, len(b))
+		}
+	}
+	return appendOps(ops, Equal, suffix)
+}
+
+func appendOps(ops []Op, op Op, n int) []Op {
+	for i := 0; i < n; i++ {
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+// maxSnakeCost bounds how many edits middleSnake explores from each end
+// before giving up, which keeps the running time of very different inputs
+// linear in their size.
+const maxSnakeCost = 4096
+
+// middleSnake searches forwards from the start and backwards from the end of
+// the edit graph at the same time and returns the point where the two
+// shortest paths meet. a and b must be non-empty and differ in their first
+// and last elements. ok is false if they have nothing in common or the paths
+// do not meet within maxSnakeCost edits, in which case callers replace the
+// whole block.
+func middleSnake(a, b []string) (x, y int, ok bool) {
+	n, m := len(a), len(b)
+	limit := min((n+m+1)/2, maxSnakeCost)
+	offset := limit
+	// forward[offset+k] is the furthest x reached on diagonal k from the
+	// start; backward likewise counts from the end.
+	forward := make([]int, 2*limit+2)
+	backward := make([]int, 2*limit+2)
+	for i := range forward {
+		forward[i] = -1
+		backward[i] = -1
+	}
+	forward[offset+1] = 0
+	backward[offset+1] = 0
+
+	delta := n - m
+	// With an odd delta the paths can only meet while extending forwards.
+	odd := delta%2 != 0
+	// Diagonals that ran off the edge of the graph are trimmed from later
+	// rounds.
+	fStart, fEnd, bStart, bEnd := 0, 0, 0, 0
+
+	for d := 0; d < limit; d++ {
+		for k := -d + fStart; k <= d-fEnd; k += 2 {
+			i := offset + k
+			var x1 int
+			if k == -d || (k != d && forward[i-1] < forward[i+1]) {
+				x1 = forward[i+1]
+			} else {
+				x1 = forward[i-1] + 1
+			}
+			y1 := x1 - k
+			for x1 < n && y1 < m && a[x1] == b[y1] {
+				x1++
+				y1++
+			}
+			forward[i] = x1
+			switch {
+			case x1 > n:
+				fEnd += 2
+			case y1 > m:
+				fStart += 2
+			case odd:
+				j := offset + delta - k
+				if j >= 0 && j < len(backward) && backward[j] != -1 && x1 >= n-backward[j] {
+					return x1, y1, true
+				}
+			}
+		}
+
+		for k := -d + bStart; k <= d-bEnd; k += 2 {
+			i := offset + k
+			var x2 int
+			if k == -d || (k != d && backward[i-1] < backward[i+1]) {
+				x2 = backward[i+1]
+			} else {
+				x2 = backward[i-1] + 1
+			}
+			y2 := x2 - k
+			for x2 < n && y2 < m && a[n-x2-1] == b[m-y2-1] {
+				x2++
+				y2++
+			}
+			backward[i] = x2
+			switch {
+			case x2 > n:
+				bEnd += 2
+			case y2 > m:
+				bStart += 2
+			case !odd:
+				j := offset + delta - k
+				if j >= 0 && j < len(forward) && forward[j] != -1 {
+					x1 := forward[j]
+					y1 := x1 - (delta - k)
+					if x1 >= n-x2 {
+						return x1, y1, true
+					}
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
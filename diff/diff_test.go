@@ -0,0 +1,172 @@
+package diff
+
+import (
+	"math/rand"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []Line
+	}{
+		{"identical", "a\nb", "a\nb", []Line{
+			{Op: Equal, OldNum: 1, NewNum: 1, Text: "a"},
+			{Op: Equal, OldNum: 2, NewNum: 2, Text: "b"},
+		}},
+		{"empty to text", "", "a", []Line{
+			{Op: Insert, NewNum: 1, Text: "a"},
+		}},
+		{"text to empty", "a", "", []Line{
+			{Op: Delete, OldNum: 1, Text: "a"},
+		}},
+		{"insert in middle", "a\nc", "a\nb\nc", []Line{
+			{Op: Equal, OldNum: 1, NewNum: 1, Text: "a"},
+			{Op: Insert, NewNum: 2, Text: "b"},
+			{Op: Equal, OldNum: 2, NewNum: 3, Text: "c"},
+		}},
+		{"crlf", "a\r\nb", "a\nb", []Line{
+			{Op: Equal, OldNum: 1, NewNum: 1, Text: "a"},
+			{Op: Equal, OldNum: 2, NewNum: 2, Text: "b"},
+		}},
+		{"modified line", "the quick fox", "the slow fox", []Line{
+			{Op: Delete, OldNum: 1, Text: "the quick fox", Segments: []Segment{
+				{Op: Equal, Text: "the "}, {Op: Delete, Text: "quick"}, {Op: Equal, Text: " fox"},
+			}},
+			{Op: Insert, NewNum: 1, Text: "the slow fox", Segments: []Segment{
+				{Op: Equal, Text: "the "}, {Op: Insert, Text: "slow"}, {Op: Equal, Text: " fox"},
+			}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Lines(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Lines(%q, %q) =\n%+v\nwant\n%+v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWords(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want []Segment
+	}{
+		{"hello world", "hello world", []Segment{{Op: Equal, Text: "hello world"}}},
+		{"hello world", "hello, world!", []Segment{
+			{Op: Equal, Text: "hello"}, {Op: Insert, Text: ","}, {Op: Equal, Text: " world"}, {Op: Insert, Text: "!"},
+		}},
+		{"one two", "three", []Segment{{Op: Delete, Text: "one two"}, {Op: Insert, Text: "three"}}},
+		{"", "new", []Segment{{Op: Insert, Text: "new"}}},
+	}
+	for _, tt := range tests {
+		if got := Words(tt.a, tt.b); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Words(%q, %q) = %+v, want %+v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestHunks(t *testing.T) {
+	var old, new []string
+	for i := 1; i <= 20; i++ {
+		old = append(old, strconv.Itoa(i))
+		new = append(new, strconv.Itoa(i))
+	}
+	new[2] = "three"
+	new[16] = "seventeen"
+
+	hunks := Hunks(Lines(strings.Join(old, "\n"), strings.Join(new, "\n")), 2)
+	if len(hunks) != 2 {
+		t.Fatalf("got %d hunks, want 2", len(hunks))
+	}
+	if h := hunks[0]; h.OldStart != 1 || h.NewStart != 1 || len(h.Lines) != 6 {
+		t.Errorf("first hunk = start %d/%d, %d lines; want 1/1, 6 lines", h.OldStart, h.NewStart, len(h.Lines))
+	}
+	if h := hunks[1]; h.OldStart != 15 || h.NewStart != 15 || len(h.Lines) != 6 {
+		t.Errorf("second hunk = start %d/%d, %d lines; want 15/15, 6 lines", h.OldStart, h.NewStart, len(h.Lines))
+	}
+	if got := Hunks(Lines("a", "a"), 3); got != nil {
+		t.Errorf("Hunks of an unchanged text = %+v, want none", got)
+	}
+}
+
+// lcsLen is the length of the longest common subsequence of a and b, which
+// a shortest edit script keeps as Equal.
+func lcsLen(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for i := range a {
+		for j := range b {
+			if a[i] == b[j] {
+				cur[j+1] = prev[j] + 1
+			} else {
+				cur[j+1] = max(prev[j+1], cur[j])
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func TestScriptShortest(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	randomLines := func() []string {
+		s := make([]string, rng.Intn(30))
+		for i := range s {
+			s[i] = string(rune('a' + rng.Intn(4)))
+		}
+		return s
+	}
+	for iter := 0; iter < 2000; iter++ {
+		a, b := randomLines(), randomLines()
+		ops := script(a, b)
+
+		var gotA, gotB []string
+		equal := 0
+		i, j := 0, 0
+		for _, op := range ops {
+			switch op {
+			case Equal:
+				if a[i] != b[j] {
+					t.Fatalf("script(%q, %q) keeps unequal elements", a, b)
+				}
+				gotA, gotB = append(gotA, a[i]), append(gotB, b[j])
+				equal++
+				i++
+				j++
+			case Delete:
+				gotA = append(gotA, a[i])
+				i++
+			case Insert:
+				gotB = append(gotB, b[j])
+				j++
+			}
+		}
+		if strings.Join(gotA, "") != strings.Join(a, "") || strings.Join(gotB, "") != strings.Join(b, "") {
+			t.Fatalf("script(%q, %q) = %v does not rebuild the inputs", a, b, ops)
+		}
+		if want := lcsLen(a, b); equal != want {
+			t.Fatalf("script(%q, %q) keeps %d elements, want %d", a, b, equal, want)
+		}
+	}
+}
+
+func TestScriptLarge(t *testing.T) {
+	const n = 50000
+	a := make([]string, n)
+	b := make([]string, n)
+	for i := range a {
+		a[i] = "old " + strconv.Itoa(i)
+		b[i] = "new " + strconv.Itoa(i)
+	}
+	b[n/2] = a[n/2]
+
+	ops := script(a, b)
+	if len(ops) < n || len(ops) > 2*n {
+		t.Fatalf("got %d ops for %d lines", len(ops), n)
+	}
+}
@@ -0,0 +1,168 @@
+package diff
+
+import "strings"
+
+// Conflict marker lines written by MergeResult.Text around unresolved chunks.
+const (
+	MarkerOurs   = "<<<<<<< your version"
+	MarkerSep    = "======="
+	MarkerTheirs = ">>>>>>> their version"
+)
+
+// MergeChunk is a run of merged lines. Resolved chunks carry their text in
+// Lines; conflicting chunks keep all three sides.
+type MergeChunk struct {
+	Conflict bool
+	Lines    []string
+	Base     []string
+	Ours     []string
+	Theirs   []string
+}
+
+// MergeResult is the outcome of a three-way merge.
+type MergeResult struct {
+	Chunks []MergeChunk
+}
+
+// HasConflicts reports whether any chunk could not be merged automatically.
+func (m *MergeResult) HasConflicts() bool {
+	for _, c := range m.Chunks {
+		if c.Conflict {
+			return true
+		}
+	}
+	return false
+}
+
+// Conflicts returns only the conflicting chunks.
+func (m *MergeResult) Conflicts() []MergeChunk {
+	var out []MergeChunk
+	for _, c := range m.Chunks {
+		if c.Conflict {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Text joins the merged lines, wrapping each conflict in marker lines with
+// our side first.
+func (m *MergeResult) Text() string {
+	var lines []string
+	for _, c := range m.Chunks {
+		if !c.Conflict {
+			lines = append(lines, c.Lines...)
+			continue
+		}
+		lines = append(lines, MarkerOurs)
+		lines = append(lines, c.Ours...)
+		lines = append(lines, MarkerSep)
+		lines = append(lines, c.Theirs...)
+		lines = append(lines, MarkerTheirs)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// HasConflictMarkers reports whether text still contains the marker lines
+// written by Text.
+func HasConflictMarkers(text string) bool {
+	for _, line := range SplitLines(text) {
+		if line == MarkerOurs || line == MarkerTheirs {
+			return true
+		}
+	}
+	return false
+}
+
+// Merge3 merges two independent edits of base line by line. Regions changed
+// on only one side are taken from that side; regions changed identically on
+// both sides are taken once; anything else becomes a conflict.
+func Merge3(base, ours, theirs string) *MergeResult {
+	baseLines := SplitLines(base)
+	ourLines := SplitLines(ours)
+	theirLines := SplitLines(theirs)
+
+	ourMatch := matches(baseLines, ourLines)
+	theirMatch := matches(baseLines, theirLines)
+
+	result := &MergeResult{}
+	i, j, k := 0, 0, 0
+	for b := 0; b <= len(baseLines); b++ {
+		// A base line kept unchanged by both sides is a stable anchor; the
+		// end of the input acts as a final anchor.
+		var oj, tk int
+		if b < len(baseLines) {
+			if ourMatch[b] < 0 || theirMatch[b] < 0 {
+				continue
+			}
+			oj, tk = ourMatch[b], theirMatch[b]
+		} else {
+			oj, tk = len(ourLines), len(theirLines)
+		}
+
+		result.add(resolve(baseLines[i:b], ourLines[j:oj], theirLines[k:tk]))
+		if b < len(baseLines) {
+			result.add(MergeChunk{Lines: []string{baseLines[b]}})
+		}
+		i, j, k = b+1, oj+1, tk+1
+	}
+	return result
+}
+
+// add appends a chunk, coalescing adjacent resolved chunks.
+func (m *MergeResult) add(c MergeChunk) {
+	if !c.Conflict && len(c.Lines) == 0 {
+		return
+	}
+	if n := len(m.Chunks); n > 0 && !c.Conflict && !m.Chunks[n-1].Conflict {
+		m.Chunks[n-1].Lines = append(m.Chunks[n-1].Lines, c.Lines...)
+		return
+	}
+	m.Chunks = append(m.Chunks, c)
+}
+
+func resolve(base, ours, theirs []string) MergeChunk {
+	switch {
+	case equalLines(ours, theirs):
+		return MergeChunk{Lines: ours}
+	case equalLines(base, ours):
+		return MergeChunk{Lines: theirs}
+	case equalLines(base, theirs):
+		return MergeChunk{Lines: ours}
+	default:
+		return MergeChunk{Conflict: true, Base: base, Ours: ours, Theirs: theirs}
+	}
+}
+
+// matches maps each index of a to the index of the line it is kept as in b,
+// or -1 if it was deleted.
+func matches(a, b []string) []int {
+	m := make([]int, len(a))
+	i, j := 0, 0
+	for _, op := range script(a, b) {
+		switch op {
+		case Equal:
+			m[i] = j
+			i++
+			j++
+		case Delete:
+			m[i] = -1
+			i++
+		case Insert:
+			j++
+		}
+	}
+	return m
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,54 @@
+package diff
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMerge3(t *testing.T) {
+	const base = "one\ntwo\nthree\nfour"
+	tests := []struct {
+		name         string
+		ours, theirs string
+		want         string
+		conflicts    int
+	}{
+		{"no changes", base, base, base, 0},
+		{"ours only", "one\nTWO\nthree\nfour", base, "one\nTWO\nthree\nfour", 0},
+		{"theirs only", base, "one\ntwo\nthree\nFOUR", "one\ntwo\nthree\nFOUR", 0},
+		{"separate regions", "ONE\ntwo\nthree\nfour", "one\ntwo\nthree\nFOUR", "ONE\ntwo\nthree\nFOUR", 0},
+		{"same change", "one\n2\nthree\nfour", "one\n2\nthree\nfour", "one\n2\nthree\nfour", 0},
+		{"insert and delete", "zero\none\ntwo\nthree\nfour", "one\ntwo\nfour", "zero\none\ntwo\nfour", 0},
+		{"conflict", "one\nmine\nthree\nfour", "one\nyours\nthree\nfour",
+			"one\n" + MarkerOurs + "\nmine\n" + MarkerSep + "\nyours\n" + MarkerTheirs + "\nthree\nfour", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := Merge3(base, tt.ours, tt.theirs)
+			if got := m.Text(); got != tt.want {
+				t.Errorf("Text() = %q, want %q", got, tt.want)
+			}
+			if got := len(m.Conflicts()); got != tt.conflicts {
+				t.Errorf("got %d conflicts, want %d", got, tt.conflicts)
+			}
+			if m.HasConflicts() != (tt.conflicts > 0) {
+				t.Errorf("HasConflicts() = %v", m.HasConflicts())
+			}
+			if HasConflictMarkers(m.Text()) != (tt.conflicts > 0) {
+				t.Errorf("HasConflictMarkers(Text()) = %v", HasConflictMarkers(m.Text()))
+			}
+		})
+	}
+}
+
+func TestMerge3ConflictSides(t *testing.T) {
+	m := Merge3("a\nb\nc", "a\nx\nc", "a\ny\ny2\nc")
+	want := []MergeChunk{
+		{Lines: []string{"a"}},
+		{Conflict: true, Base: []string{"b"}, Ours: []string{"x"}, Theirs: []string{"y", "y2"}},
+		{Lines: []string{"c"}},
+	}
+	if !reflect.DeepEqual(m.Chunks, want) {
+		t.Errorf("Chunks = %+v, want %+v", m.Chunks, want)
+	}
+}
@@ -0,0 +1,9 @@
+package main
+
+import "embed"
+
+// embeddedAssets is the asset tree built into the binary, used unless
+// server.assets_dir or -dev points at a directory on disk.
+//
+//go:embed templates static database/migrations
+var embeddedAssets embed.FS
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"silic0n-wiki/middleware"
+	"silic0n-wiki/models"
+)
+
+const wantedPagesLimit = 100
+
+// reportPeriods are the day ranges offered on the wanted pages report.
+var reportPeriods = []int{7, 30, 90, 365}
+
+// WantedPages reports the missing pages readers look for most: searches
+// that found nothing, combined with links to slugs that do not exist.
+func WantedPages(w http.ResponseWriter, r *http.Request) {
+	days := 30
+	if n, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && n > 0 {
+		days = n
+	}
+	since := time.Now().AddDate(0, 0, -days)
+
+	stats, err := models.GetSearchStats(since)
+	if err != nil {
+		log.Printf("Error fetching search stats: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	wanted, err := models.GetWantedPages(since, wantedPagesLimit)
+	if err != nil {
+		log.Printf("Error fetching wanted pages: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	files := []string{
+		"./templates/base.tmpl.html",
+		"./templates/wanted.tmpl.html",
+	}
+
+	data := struct {
+		Days    int
+		Periods []int
+		Stats   *models.SearchStats
+		Wanted  []models.WantedPage
+	}{
+		Days:    days,
+		Periods: reportPeriods,
+		Stats:   stats,
+		Wanted:  wanted,
+	}
+
+	renderTemplate(w, r, files, data)
+}
+
+func AdminUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := models.GetAllUsers()
+	if err != nil {
+		log.Printf("Error fetching users: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	files := []string{
+		"./templates/base.tmpl.html",
+		"./templates/users.tmpl.html",
+	}
+
+	data := struct {
+		Users []models.User
+		Roles []models.Role
+	}{
+		Users: users,
+		Roles: models.Roles,
+	}
+
+	renderTemplate(w, r, files, data)
+}
+
+func AdminSetUserRole(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	role := models.Role(r.FormValue("role"))
+	if !role.Valid() {
+		http.Error(w, "Invalid role", http.StatusBadRequest)
+		return
+	}
+
+	// Admins cannot demote themselves, so there is always at least one.
+	if id == middleware.GetUser(r).ID {
+		http.Error(w, "You cannot change your own role", http.StatusBadRequest)
+		return
+	}
+
+	if err := models.SetUserRole(id, role); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error setting user role: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
@@ -7,6 +7,8 @@ import (
 	"strconv"
 	"strings"
 
+	"silic0n-wiki/diff"
+	"silic0n-wiki/markup"
 	"silic0n-wiki/middleware"
 	"silic0n-wiki/models"
 )
@@ -21,7 +23,15 @@ func Article(w http.ResponseWriter, r *http.Request) {
 	article, err := models.GetArticleBySlug(slug)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			http.Error(w, "Article not found", http.StatusNotFound)
+			if target, aliasErr := models.ResolveSlugAlias(slug); aliasErr == nil {
+				location := "/wiki/" + target
+				if r.URL.RawQuery != "" {
+					location += "?" + r.URL.RawQuery
+				}
+				http.Redirect(w, r, location, http.StatusMovedPermanently)
+				return
+			}
+			renderArticleNotFound(w, r, slug)
 			return
 		}
 		log.Printf("Error fetching article: %v", err)
@@ -36,12 +46,102 @@ func Article(w http.ResponseWriter, r *http.Request) {
 		article.Tags = tags
 	}
 
+	data := articlePageData{ArticleWithCategory: article}
+
+	if oldIDStr := r.URL.Query().Get("oldid"); oldIDStr != "" {
+		oldID, _ := strconv.Atoi(oldIDStr)
+		rev, err := models.GetRevision(oldID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "Revision not found", http.StatusNotFound)
+				return
+			}
+			log.Printf("Error fetching revision: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if rev.ArticleID != article.ID {
+			http.Error(w, "Revision not found", http.StatusNotFound)
+			return
+		}
+		applyRevision(article, rev)
+		data.OldRevision = rev
+	}
+
 	files := []string{
 		"./templates/base.tmpl.html",
 		"./templates/article.tmpl.html",
 	}
 
-	renderTemplate(w, r, files, article)
+	renderTemplate(w, r, files, data)
+}
+
+// renderArticleNotFound shows a 404 page suggesting the existing articles
+// closest to the requested slug.
+func renderArticleNotFound(w http.ResponseWriter, r *http.Request, slug string) {
+	title := strings.ReplaceAll(slug, "-", " ")
+	suggestions, err := models.SuggestArticles(title, 5)
+	if err != nil {
+		log.Printf("Error fetching article suggestions: %v", err)
+	}
+
+	files := []string{
+		"./templates/base.tmpl.html",
+		"./templates/not_found.tmpl.html",
+	}
+
+	data := struct {
+		Slug        string
+		Title       string
+		Suggestions []models.Article
+	}{
+		Slug:        slug,
+		Title:       title,
+		Suggestions: suggestions,
+	}
+
+	renderTemplateStatus(w, r, http.StatusNotFound, files, data)
+}
+
+type articlePageData struct {
+	*models.ArticleWithCategory
+	OldRevision *models.Revision
+}
+
+// applyRevision overlays a stored revision onto the article so the regular
+// article template renders it.
+func applyRevision(article *models.ArticleWithCategory, rev *models.Revision) {
+	article.Title = rev.Title
+	article.Content = rev.Content
+	article.Outline = markup.ParseOutline(rev.Content)
+	article.CategoryID = rev.CategoryID
+	article.CategoryName = rev.CategoryName
+	article.CategorySlug = rev.CategorySlug
+	article.LastEditedBy = rev.EditedBy
+	article.UpdatedAt = rev.CreatedAt
+
+	article.Tags = nil
+	for _, name := range rev.Tags {
+		article.Tags = append(article.Tags, models.TagWithCategory{
+			Tag:          models.Tag{Name: name, Slug: models.Slugify(name), CategoryID: rev.CategoryID},
+			CategoryName: rev.CategoryName,
+			CategorySlug: rev.CategorySlug,
+		})
+	}
+}
+
+type articleFormData struct {
+	IsEdit          bool
+	Article         *models.Article
+	Categories      []models.Category
+	ArticleTags     []models.TagWithCategory
+	TagString       string
+	NewCategoryName string
+	Summary         string
+	BaseRevisionID  int
+	Section         *markup.Section
+	Conflict        *editConflict
+	Errors          []string
 }
 
 func CreateArticlePage(w http.ResponseWriter, r *http.Request) {
@@ -57,19 +157,16 @@ func CreateArticlePage(w http.ResponseWriter, r *http.Request) {
 		"./templates/article_form.tmpl.html",
 	}
 
-	data := struct {
-		IsEdit          bool
-		Article         *models.Article
-		Categories      []models.Category
-		ArticleTags     []models.TagWithCategory
-		TagString       string
-		NewCategoryName string
-		Errors          []string
-	}{
+	data := articleFormData{
 		IsEdit:     false,
 		Categories: categories,
 	}
 
+	// Red links pass the missing page's title so the form starts filled in.
+	if title := strings.TrimSpace(r.URL.Query().Get("title")); title != "" {
+		data.Article = &models.Article{Title: title}
+	}
+
 	renderTemplate(w, r, files, data)
 }
 
@@ -82,6 +179,7 @@ func CreateArticleSubmit(w http.ResponseWriter, r *http.Request) {
 	categoryIDStr := r.FormValue("category_id")
 	newCategoryName := strings.TrimSpace(r.FormValue("new_category_name"))
 	tagsStr := r.FormValue("tags")
+	summary := strings.TrimSpace(r.FormValue("summary"))
 
 	var errors []string
 	if title == "" {
@@ -93,7 +191,9 @@ func CreateArticleSubmit(w http.ResponseWriter, r *http.Request) {
 
 	var categoryID int
 	if categoryIDStr == "new" {
-		if newCategoryName == "" {
+		if !user.Can(models.PermCategoryManage) {
+			errors = append(errors, "You do not have permission to create categories")
+		} else if newCategoryName == "" {
 			errors = append(errors, "Category name is required when creating a new category")
 		}
 	} else {
@@ -109,20 +209,13 @@ func CreateArticleSubmit(w http.ResponseWriter, r *http.Request) {
 			"./templates/base.tmpl.html",
 			"./templates/article_form.tmpl.html",
 		}
-		data := struct {
-			IsEdit          bool
-			Article         *models.Article
-			Categories      []models.Category
-			ArticleTags     []models.TagWithCategory
-			TagString       string
-			NewCategoryName string
-			Errors          []string
-		}{
+		data := articleFormData{
 			IsEdit:          false,
 			Article:         &models.Article{Title: title, Content: content, CategoryID: categoryID},
 			Categories:      categories,
 			TagString:       tagsStr,
 			NewCategoryName: newCategoryName,
+			Summary:         summary,
 			Errors:          errors,
 		}
 		renderTemplate(w, r, files, data)
@@ -139,24 +232,22 @@ func CreateArticleSubmit(w http.ResponseWriter, r *http.Request) {
 		categoryID = cat.ID
 	}
 
-	article, err := models.CreateArticle(title, content, categoryID, user.Username)
+	tagIDs, err := models.ResolveTagNames(tagsStr, categoryID)
+	if err != nil {
+		log.Printf("Error resolving tags: %v", err)
+	}
+
+	if summary == "" {
+		summary = "Created page"
+	}
+
+	article, err := models.CreateArticle(title, content, categoryID, tagIDs, user.Username, summary)
 	if err != nil {
 		log.Printf("Error creating article: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	if tagsStr != "" {
-		tagIDs, err := models.ResolveTagNames(tagsStr, categoryID)
-		if err != nil {
-			log.Printf("Error resolving tags: %v", err)
-		} else if len(tagIDs) > 0 {
-			if err := models.SetArticleTags(article.ID, tagIDs); err != nil {
-				log.Printf("Error setting tags: %v", err)
-			}
-		}
-	}
-
 	http.Redirect(w, r, "/wiki/"+article.Slug, http.StatusSeeOther)
 }
 
@@ -172,6 +263,9 @@ func EditArticlePage(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	if !requireEditable(w, r, article) {
+		return
+	}
 
 	categories, err := models.GetAllCategories()
 	if err != nil {
@@ -205,25 +299,52 @@ func EditArticlePage(w http.ResponseWriter, r *http.Request) {
 	}
 	tagString := strings.Join(tagNames, ", ")
 
-	data := struct {
-		IsEdit          bool
-		Article         *models.Article
-		Categories      []models.Category
-		ArticleTags     []models.TagWithCategory
-		TagString       string
-		NewCategoryName string
-		Errors          []string
-	}{
-		IsEdit:      true,
-		Article:     formArticle,
-		Categories:  categories,
-		ArticleTags: articleTags,
-		TagString:   tagString,
+	baseRevisionID, err := models.GetLatestRevisionID(article.ID)
+	if err != nil {
+		log.Printf("Error fetching latest revision: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	data := articleFormData{
+		IsEdit:         true,
+		Article:        formArticle,
+		Categories:     categories,
+		ArticleTags:    articleTags,
+		TagString:      tagString,
+		BaseRevisionID: baseRevisionID,
+	}
+
+	if param := r.URL.Query().Get("section"); param != "" {
+		section, ok := findSection(article.Content, param)
+		if !ok {
+			http.Error(w, "Section not found", http.StatusNotFound)
+			return
+		}
+		formArticle.Content, _ = markup.ExtractSection(article.Content, section.Index)
+		data.Section = section
+		if section.Title != "" {
+			data.Summary = "Edited section \"" + section.Title + "\""
+		}
 	}
 
 	renderTemplate(w, r, files, data)
 }
 
+// findSection looks up a section by the number given in a ?section= query
+// parameter.
+func findSection(content, param string) (*markup.Section, bool) {
+	n, err := strconv.Atoi(param)
+	if err != nil {
+		return nil, false
+	}
+	sections := markup.Sections(content)
+	if n < 0 || n >= len(sections) {
+		return nil, false
+	}
+	return &sections[n], true
+}
+
 func EditArticleSubmit(w http.ResponseWriter, r *http.Request) {
 	user := middleware.GetUser(r)
 	slug := r.PathValue("slug")
@@ -238,6 +359,9 @@ func EditArticleSubmit(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	if !requireEditable(w, r, existingArticle) {
+		return
+	}
 
 	r.ParseForm()
 	title := strings.TrimSpace(r.FormValue("title"))
@@ -245,6 +369,34 @@ func EditArticleSubmit(w http.ResponseWriter, r *http.Request) {
 	categoryIDStr := r.FormValue("category_id")
 	newCategoryName := strings.TrimSpace(r.FormValue("new_category_name"))
 	tagsStr := r.FormValue("tags")
+	summary := strings.TrimSpace(r.FormValue("summary"))
+	baseRevisionID, _ := strconv.Atoi(r.FormValue("base_revision"))
+
+	// A section edit is spliced into the revision it was loaded from, so the
+	// merge below only sees changes to that section.
+	var section *markup.Section
+	baseContent := existingArticle.Content
+	if param := r.URL.Query().Get("section"); param != "" {
+		if baseRevisionID != 0 {
+			rev, err := models.GetRevision(baseRevisionID)
+			if err != nil && err != sql.ErrNoRows {
+				log.Printf("Error fetching base revision: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if err != nil || rev.ArticleID != existingArticle.ID {
+				http.Error(w, "Revision not found", http.StatusNotFound)
+				return
+			}
+			baseContent = rev.Content
+		}
+		var ok bool
+		section, ok = findSection(baseContent, param)
+		if !ok {
+			http.Error(w, "Section not found", http.StatusNotFound)
+			return
+		}
+	}
 
 	var errors []string
 	if title == "" {
@@ -253,10 +405,15 @@ func EditArticleSubmit(w http.ResponseWriter, r *http.Request) {
 	if content == "" {
 		errors = append(errors, "Content is required")
 	}
+	if diff.HasConflictMarkers(content) {
+		errors = append(errors, "Content still contains conflict markers; resolve them before saving")
+	}
 
 	var categoryID int
 	if categoryIDStr == "new" {
-		if newCategoryName == "" {
+		if !user.Can(models.PermCategoryManage) {
+			errors = append(errors, "You do not have permission to create categories")
+		} else if newCategoryName == "" {
 			errors = append(errors, "Category name is required when creating a new category")
 		}
 	} else {
@@ -273,21 +430,16 @@ func EditArticleSubmit(w http.ResponseWriter, r *http.Request) {
 			"./templates/base.tmpl.html",
 			"./templates/article_form.tmpl.html",
 		}
-		data := struct {
-			IsEdit          bool
-			Article         *models.Article
-			Categories      []models.Category
-			ArticleTags     []models.TagWithCategory
-			TagString       string
-			NewCategoryName string
-			Errors          []string
-		}{
+		data := articleFormData{
 			IsEdit:          true,
 			Article:         &models.Article{ID: existingArticle.ID, Slug: slug, Title: title, Content: content, CategoryID: categoryID},
 			Categories:      categories,
 			ArticleTags:     articleTags,
 			TagString:       tagsStr,
 			NewCategoryName: newCategoryName,
+			Summary:         summary,
+			BaseRevisionID:  baseRevisionID,
+			Section:         section,
 			Errors:          errors,
 		}
 		renderTemplate(w, r, files, data)
@@ -304,27 +456,109 @@ func EditArticleSubmit(w http.ResponseWriter, r *http.Request) {
 		categoryID = cat.ID
 	}
 
-	updatedArticle, err := models.UpdateArticle(existingArticle.ID, title, content, categoryID, user.Username)
-	if err != nil {
-		log.Printf("Error updating article: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+	if section != nil {
+		content, _ = markup.ReplaceSection(baseContent, section.Index, content)
+	}
+
+	edit := models.ArticleEdit{
+		Title:      title,
+		Content:    content,
+		CategoryID: categoryID,
+		Tags:       models.ParseTagNames(tagsStr),
 	}
 
-	if tagsStr != "" {
-		tagIDs, err := models.ResolveTagNames(tagsStr, categoryID)
+	// Saves race against other editors: when the base revision is stale the
+	// edit is merged with the newer revision and retried, and only a real
+	// conflict is sent back to the editor.
+	var updatedArticle *models.Article
+	for attempt := 0; updatedArticle == nil; attempt++ {
+		latestID, err := models.GetLatestRevisionID(existingArticle.ID)
+		if err != nil {
+			log.Printf("Error fetching latest revision: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if baseRevisionID != 0 && baseRevisionID != latestID {
+			merge, err := models.MergeEdit(baseRevisionID, latestID, edit)
+			if err != nil {
+				log.Printf("Error merging edit: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if merge.HasConflicts() || attempt >= maxSaveAttempts {
+				renderEditConflict(w, r, existingArticle, merge, edit, summary)
+				return
+			}
+			edit = merge.Merged
+			baseRevisionID = latestID
+		}
+
+		tagIDs, err := models.ResolveTagNames(strings.Join(edit.Tags, ","), edit.CategoryID)
 		if err != nil {
 			log.Printf("Error resolving tags: %v", err)
-		} else {
-			if err := models.SetArticleTags(updatedArticle.ID, tagIDs); err != nil {
-				log.Printf("Error setting tags: %v", err)
+			existingTags, _ := models.GetTagsForArticle(existingArticle.ID)
+			tagIDs = nil
+			for _, t := range existingTags {
+				tagIDs = append(tagIDs, t.ID)
 			}
 		}
-	} else {
-		if err := models.SetArticleTags(updatedArticle.ID, nil); err != nil {
-			log.Printf("Error clearing tags: %v", err)
+
+		updatedArticle, err = models.UpdateArticle(existingArticle.ID, baseRevisionID, edit.Title, edit.Content,
+			edit.CategoryID, tagIDs, user.Username, summary)
+		if err == models.ErrEditConflict {
+			continue
+		}
+		if err != nil {
+			log.Printf("Error updating article: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
 		}
 	}
 
 	http.Redirect(w, r, "/wiki/"+updatedArticle.Slug, http.StatusSeeOther)
 }
+
+const maxSaveAttempts = 3
+
+// editConflict describes a save that could not be merged automatically.
+type editConflict struct {
+	Theirs        *models.Revision
+	TheirChanges  []diff.Hunk
+	MyContent     string
+	TitleConflict bool
+}
+
+// renderEditConflict re-displays the edit form with conflict markers in the
+// content, rebased onto the newer revision so the next save goes through.
+func renderEditConflict(w http.ResponseWriter, r *http.Request, existing *models.ArticleWithCategory,
+	merge *models.EditMerge, mine models.ArticleEdit, summary string) {
+	categories, _ := models.GetAllCategories()
+	files := []string{
+		"./templates/base.tmpl.html",
+		"./templates/article_form.tmpl.html",
+	}
+	data := articleFormData{
+		IsEdit: true,
+		Article: &models.Article{
+			ID:         existing.ID,
+			Slug:       existing.Slug,
+			Title:      merge.Merged.Title,
+			Content:    merge.Merged.Content,
+			CategoryID: merge.Merged.CategoryID,
+		},
+		Categories:     categories,
+		TagString:      strings.Join(merge.Merged.Tags, ", "),
+		Summary:        summary,
+		BaseRevisionID: merge.Theirs.ID,
+		Conflict: &editConflict{
+			Theirs:        merge.Theirs,
+			TheirChanges:  diff.Hunks(diff.Lines(merge.Base.Content, merge.Theirs.Content), 3),
+			MyContent:     mine.Content,
+			TitleConflict: merge.TitleConflict,
+		},
+		Errors: []string{"This article was changed by " + merge.Theirs.EditedBy +
+			" while you were editing. Review the conflicts below and save again."},
+	}
+	renderTemplate(w, r, files, data)
+}
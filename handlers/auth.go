@@ -4,7 +4,6 @@ import (
 	"database/sql"
 	"log"
 	"net/http"
-	"regexp"
 	"strings"
 	"time"
 
@@ -47,18 +46,16 @@ func RegisterSubmit(w http.ResponseWriter, r *http.Request) {
 
 	var errors []string
 
-	if len(username) < 3 || len(username) > 50 {
-		errors = append(errors, "Username must be between 3 and 50 characters")
-	} else if matched, _ := regexp.MatchString(`^[a-zA-Z0-9_]+$`, username); !matched {
-		errors = append(errors, "Username may only contain letters, numbers, and underscores")
+	if err := models.ValidateUsername(username); err != nil {
+		errors = append(errors, err.Error())
 	}
 
-	if !strings.Contains(email, "@") || !strings.Contains(email, ".") {
-		errors = append(errors, "Please enter a valid email address")
+	if err := models.ValidateEmail(email); err != nil {
+		errors = append(errors, err.Error())
 	}
 
-	if len(password) < 8 {
-		errors = append(errors, "Password must be at least 8 characters")
+	if err := models.ValidatePassword(password); err != nil {
+		errors = append(errors, err.Error())
 	}
 
 	if password != passwordConfirm {
@@ -184,6 +181,22 @@ func LoginSubmit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if user.Disabled {
+		files := []string{
+			"./templates/base.tmpl.html",
+			"./templates/login.tmpl.html",
+		}
+		data := struct {
+			Error    string
+			Username string
+		}{
+			Error:    "This account has been disabled",
+			Username: username,
+		}
+		renderTemplate(w, r, files, data)
+		return
+	}
+
 	if err := createSessionAndRedirect(w, r, user.ID, "/"); err != nil {
 		log.Printf("Error creating session: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
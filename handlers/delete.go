@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"silic0n-wiki/middleware"
+	"silic0n-wiki/models"
+)
+
+type deleteFormData struct {
+	Article       *models.ArticleWithCategory
+	Reason        string
+	IncomingLinks []models.Backlink
+	Errors        []string
+}
+
+func DeleteArticlePage(w http.ResponseWriter, r *http.Request) {
+	slug := r.PathValue("slug")
+	article, err := models.GetArticleBySlug(slug)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Article not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error fetching article: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !requireEditable(w, r, article) {
+		return
+	}
+
+	incoming, err := models.GetLinksToSlug(article.Slug, article.ID)
+	if err != nil {
+		log.Printf("Error fetching incoming links: %v", err)
+	}
+
+	renderDeleteForm(w, r, deleteFormData{
+		Article:       article,
+		IncomingLinks: incoming,
+	})
+}
+
+func DeleteArticleSubmit(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+	slug := r.PathValue("slug")
+
+	article, err := models.GetArticleBySlug(slug)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Article not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error fetching article: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !requireEditable(w, r, article) {
+		return
+	}
+
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if reason == "" {
+		incoming, _ := models.GetLinksToSlug(article.Slug, article.ID)
+		renderDeleteForm(w, r, deleteFormData{
+			Article:       article,
+			IncomingLinks: incoming,
+			Errors:        []string{"A reason is required"},
+		})
+		return
+	}
+
+	if err := models.DeleteArticle(article.ID, user.Username, reason); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Article not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error deleting article: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func renderDeleteForm(w http.ResponseWriter, r *http.Request, data deleteFormData) {
+	files := []string{
+		"./templates/base.tmpl.html",
+		"./templates/delete.tmpl.html",
+	}
+	renderTemplate(w, r, files, data)
+}
+
+// deletionLogLimit is how many log entries the trash page shows.
+const deletionLogLimit = 50
+
+func Trash(w http.ResponseWriter, r *http.Request) {
+	articles, err := models.GetDeletedArticles()
+	if err != nil {
+		log.Printf("Error fetching deleted articles: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	entries, err := models.GetDeletionLog(deletionLogLimit)
+	if err != nil {
+		log.Printf("Error fetching deletion log: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	files := []string{
+		"./templates/base.tmpl.html",
+		"./templates/trash.tmpl.html",
+	}
+
+	data := struct {
+		Articles []models.DeletedArticle
+		Log      []models.DeletionLogEntry
+	}{
+		Articles: articles,
+		Log:      entries,
+	}
+
+	renderTemplate(w, r, files, data)
+}
+
+func RestoreArticle(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Article not found", http.StatusNotFound)
+		return
+	}
+
+	article, err := models.RestoreArticle(id, user.Username)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Article not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error restoring article: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/wiki/"+article.Slug, http.StatusSeeOther)
+}
+
+func PurgeArticle(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Article not found", http.StatusNotFound)
+		return
+	}
+
+	if err := models.PurgeArticle(id, user.Username); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Article not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error purging article: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/trash", http.StatusSeeOther)
+}
@@ -5,13 +5,14 @@ import (
 	"html/template"
 	"log"
 	"net/http"
-	"path/filepath"
-	"regexp"
-	"strings"
+	"path"
 
+	"silic0n-wiki/assets"
 	"silic0n-wiki/auth"
+	"silic0n-wiki/markup"
 	"silic0n-wiki/middleware"
 	"silic0n-wiki/models"
+	"silic0n-wiki/thumbnail"
 )
 
 type PageData struct {
@@ -34,12 +35,36 @@ func newPageData(r *http.Request, data interface{}) PageData {
 	}
 }
 
+// Can reports whether the current user may perform perm, so templates can
+// hide actions with {{if .Can "article.edit"}}.
+func (p PageData) Can(perm string) bool {
+	return p.User.Can(models.Permission(perm))
+}
+
+// CanEdit reports whether the current user may edit a page with the given
+// protection.
+func (p PageData) CanEdit(protection models.Protection) bool {
+	return p.User.CanEdit(protection)
+}
+
 func renderTemplate(w http.ResponseWriter, r *http.Request, templates []string, data interface{}) {
+	renderTemplateStatus(w, r, http.StatusOK, templates, data)
+}
+
+// renderTemplateStatus renders a page with a status code other than 200.
+func renderTemplateStatus(w http.ResponseWriter, r *http.Request, status int, templates []string, data interface{}) {
 	funcMap := template.FuncMap{
-		"renderContent": RenderArticleContent,
+		"renderContent":         RenderArticleContent,
+		"renderEditableContent": RenderEditableArticleContent,
 	}
 
-	ts, err := template.New("").Funcs(funcMap).ParseFiles(templates...)
+	// Template paths are written relative to the source tree ("./templates/…"),
+	// which fs.FS does not accept with the leading "./".
+	names := make([]string, len(templates))
+	for i, t := range templates {
+		names[i] = path.Clean(t)
+	}
+	ts, err := template.New("").Funcs(funcMap).ParseFS(assets.FS, names...)
 	if err != nil {
 		log.Printf("Error parsing templates: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -47,6 +72,9 @@ func renderTemplate(w http.ResponseWriter, r *http.Request, templates []string,
 	}
 
 	pageData := newPageData(r, data)
+	if status != http.StatusOK {
+		w.WriteHeader(status)
+	}
 	err = ts.ExecuteTemplate(w, "base.tmpl.html", pageData)
 	if err != nil {
 		log.Printf("Error executing template: %v", err)
@@ -55,76 +83,56 @@ func renderTemplate(w http.ResponseWriter, r *http.Request, templates []string,
 	}
 }
 
-// Matches ![alt](filename =WIDTHxHEIGHT center) — size and center are both optional
-var mediaEmbedRegex = regexp.MustCompile(`!\[([^\]]*)\]\(([^\s)]+)(?:\s*=(\d*)x(\d*))?(\s+center)?\)`)
-
 func RenderArticleContent(content string) template.HTML {
-	escaped := template.HTMLEscapeString(content)
-
-	result := mediaEmbedRegex.ReplaceAllStringFunc(escaped, func(match string) string {
-		submatches := mediaEmbedRegex.FindStringSubmatch(match)
-		if len(submatches) != 6 {
-			return match
-		}
-		alt := submatches[1]
-		filename := submatches[2]
-		widthStr := submatches[3]
-		heightStr := submatches[4]
-		centered := strings.TrimSpace(submatches[5]) == "center"
-
-		if !isValidMediaFilename(filename) {
-			return match
-		}
-
-		mediaURL := "/media/" + filename
-		ext := strings.ToLower(filepath.Ext(filename))
-
-		style := buildSizeStyle(widthStr, heightStr)
-		divClass := "media-embed"
-		if centered {
-			divClass += " media-center"
-		}
-
-		switch ext {
-		case ".mp4", ".webm":
-			return fmt.Sprintf(
-				`<div class="%s media-video"><video controls preload="metadata" title="%s"%s><source src="%s">Your browser does not support video playback.</video></div>`,
-				divClass, alt, style, mediaURL,
-			)
-		case ".jpg", ".jpeg", ".png", ".gif", ".webp":
-			return fmt.Sprintf(
-				`<div class="%s media-image"><img src="%s" alt="%s"%s loading="lazy"></div>`,
-				divClass, mediaURL, alt, style,
-			)
-		default:
-			return match
-		}
-	})
-
-	result = strings.ReplaceAll(result, "\n", "<br>\n")
+	return template.HTML(markup.RenderWith(content, markup.Options{
+		LinkExists: linkExists,
+		Images:     mediaImages,
+	}))
+}
 
-	return template.HTML(result)
+// RenderEditableArticleContent renders content with an edit link on every
+// section heading.
+func RenderEditableArticleContent(content, slug string) template.HTML {
+	return template.HTML(markup.RenderWith(content, markup.Options{
+		LinkExists: linkExists,
+		Images:     mediaImages,
+		SectionEditURL: func(n int) string {
+			return fmt.Sprintf("/wiki/%s/edit?section=%d", slug, n)
+		},
+	}))
 }
 
-func buildSizeStyle(widthStr, heightStr string) string {
-	if widthStr == "" && heightStr == "" {
-		return ""
-	}
-	var parts []string
-	if widthStr != "" {
-		parts = append(parts, "width:"+widthStr+"px")
-	}
-	if heightStr != "" {
-		parts = append(parts, "height:"+heightStr+"px")
+// linkExists resolves wiki links for the renderer in one query. If the lookup
+// fails every link is shown as existing rather than as a red link.
+func linkExists(slugs []string) map[string]bool {
+	existing, err := models.ExistingSlugs(slugs)
+	if err != nil {
+		log.Printf("Error resolving wiki links: %v", err)
+		existing = make(map[string]bool)
+		for _, slug := range slugs {
+			existing[slug] = true
+		}
 	}
-	return fmt.Sprintf(` style="%s"`, strings.Join(parts, ";"))
+	return existing
 }
 
-func isValidMediaFilename(filename string) bool {
-	for _, c := range filename {
-		if !((c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '.') {
-			return false
+// mediaImages looks up the size and resized variants of embedded images in
+// one query. If the lookup fails images render without them.
+func mediaImages(filenames []string) map[string]markup.Image {
+	media, err := models.GetMediaByFilenames(filenames)
+	if err != nil {
+		log.Printf("Error looking up embedded media: %v", err)
+		return nil
+	}
+	images := make(map[string]markup.Image)
+	for name, m := range media {
+		if m.IsImage() && m.Width > 0 {
+			images[name] = markup.Image{
+				Width:    m.Width,
+				Height:   m.Height,
+				Variants: thumbnail.Variants(m.MimeType, m.Width),
+			}
 		}
 	}
-	return len(filename) > 0 && !strings.Contains(filename, "..")
+	return images
 }
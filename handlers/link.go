@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+
+	"silic0n-wiki/models"
+)
+
+func ArticleBacklinks(w http.ResponseWriter, r *http.Request) {
+	slug := r.PathValue("slug")
+	article, err := models.GetArticleBySlug(slug)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Article not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error fetching article: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	backlinks, err := models.GetBacklinks(article.ID)
+	if err != nil {
+		log.Printf("Error fetching backlinks: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	files := []string{
+		"./templates/base.tmpl.html",
+		"./templates/backlinks.tmpl.html",
+	}
+
+	data := struct {
+		Article   *models.ArticleWithCategory
+		Backlinks []models.Backlink
+	}{
+		Article:   article,
+		Backlinks: backlinks,
+	}
+
+	renderTemplate(w, r, files, data)
+}
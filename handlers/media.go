@@ -1,19 +1,24 @@
 package handlers
 
 import (
-	"crypto/rand"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 
 	"silic0n-wiki/config"
+	"silic0n-wiki/mediafile"
 	"silic0n-wiki/middleware"
 	"silic0n-wiki/models"
+	"silic0n-wiki/thumbnail"
 )
 
 func MediaUpload(w http.ResponseWriter, r *http.Request) {
@@ -44,36 +49,168 @@ func MediaUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ext := strings.ToLower(filepath.Ext(header.Filename))
-	if ext == "" {
-		ext = extensionFromMIME(mimeType)
+	// The declared type comes from the client, so the content has to
+	// agree with it before the file is stored and served under that type.
+	sniffed, err := mediafile.SniffReader(file)
+	if err != nil {
+		log.Printf("Error reading upload: %v", err)
+		jsonError(w, "Failed to read file.", http.StatusBadRequest)
+		return
+	}
+	if !strings.EqualFold(sniffed, mimeType) {
+		jsonError(w, "File content does not match its type.", http.StatusBadRequest)
+		return
 	}
-	uuidName := generateUUID() + ext
-	filePath := filepath.Join(config.AppConfig.Media.UploadDir, uuidName)
+	mimeType = sniffed
 
-	dst, err := os.Create(filePath)
+	// Uploads from the edit page belong to the article being edited; new
+	// articles have no id yet and claim their media when first saved. Only
+	// someone who may edit the article can attach files to it.
+	var articleID *int
+	if id, err := strconv.Atoi(r.FormValue("article_id")); err == nil && id > 0 {
+		article, err := models.GetLiveArticleByID(id)
+		if err == sql.ErrNoRows {
+			jsonError(w, "Article not found.", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error fetching article: %v", err)
+			jsonError(w, "Failed to save file.", http.StatusInternalServerError)
+			return
+		}
+		if !user.CanEdit(article.Protection) {
+			jsonError(w, "This page is protected ("+article.Protection.Current().Label()+").", http.StatusForbidden)
+			return
+		}
+		articleID = &id
+	}
+
+	uploadDir := config.AppConfig.Media.UploadDir
+	tmp, err := os.CreateTemp(uploadDir, ".upload-*")
 	if err != nil {
 		log.Printf("Error creating file: %v", err)
 		jsonError(w, "Failed to save file.", http.StatusInternalServerError)
 		return
 	}
-	defer dst.Close()
+	tmpPath := tmp.Name()
+	// Once the file is moved into place this does nothing.
+	defer os.Remove(tmpPath)
 
-	if _, err := io.Copy(dst, file); err != nil {
+	_, err = io.Copy(tmp, file)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
 		log.Printf("Error writing file: %v", err)
-		os.Remove(filePath)
 		jsonError(w, "Failed to save file.", http.StatusInternalServerError)
 		return
 	}
 
-	media, err := models.CreateMedia(nil, uuidName, header.Filename, filePath, mimeType, header.Size, user.Username)
+	// Stripping decodes the whole image, so its declared size is checked
+	// first: a few kilobytes can describe gigabytes of pixels.
+	maxPixels := config.AppConfig.Media.PixelLimit()
+	if err := mediafile.CheckPixels(tmpPath, mimeType, maxPixels); err != nil {
+		switch err {
+		case mediafile.ErrInvalid:
+			jsonError(w, "File is not a valid image.", http.StatusBadRequest)
+		case mediafile.ErrTooLarge:
+			jsonError(w, fmt.Sprintf("Image is too large (limit is %d megapixels).", maxPixels/1_000_000), http.StatusBadRequest)
+		default:
+			log.Printf("Error reading image size: %v", err)
+			jsonError(w, "Failed to save file.", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if err := mediafile.Strip(tmpPath, mimeType); err != nil {
+		if err == mediafile.ErrInvalid {
+			jsonError(w, "File is not a valid image.", http.StatusBadRequest)
+			return
+		}
+		log.Printf("Error stripping metadata: %v", err)
+		jsonError(w, "Failed to save file.", http.StatusInternalServerError)
+		return
+	}
+
+	// Files are stored under the hash of their cleaned content, so an
+	// identical upload is answered with the file already stored.
+	sum, err := mediafile.SHA256(tmpPath)
+	if err != nil {
+		log.Printf("Error hashing file: %v", err)
+		jsonError(w, "Failed to save file.", http.StatusInternalServerError)
+		return
+	}
+	existing, err := models.GetMediaBySHA256(sum)
+	if err == nil {
+		writeLinkedMediaJSON(w, existing, articleID)
+		return
+	}
+	if err != sql.ErrNoRows {
+		log.Printf("Error looking up media by hash: %v", err)
+		jsonError(w, "Failed to save file.", http.StatusInternalServerError)
+		return
+	}
+
+	// The extension follows the checked type, never the client's name.
+	ext := extensionFromMIME(mimeType)
+	filename := sum + ext
+	relPath := models.MediaStoragePath(sum, ext)
+	filePath := filepath.Join(uploadDir, relPath)
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		log.Printf("Error creating media directory: %v", err)
+		jsonError(w, "Failed to save file.", http.StatusInternalServerError)
+		return
+	}
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		log.Printf("Error moving file into place: %v", err)
+		jsonError(w, "Failed to save file.", http.StatusInternalServerError)
+		return
+	}
+	// From here on the file may already be shared with a record made by a
+	// concurrent upload of the same content, so it is never removed.
+	info, err := os.Stat(filePath)
+	if err != nil {
+		log.Printf("Error reading saved file: %v", err)
+		jsonError(w, "Failed to save file.", http.StatusInternalServerError)
+		return
+	}
+
+	// Variants are an optimisation: if they cannot be made now the
+	// thumbnail endpoint retries on first request.
+	var width, height int
+	if thumbnail.Supported(mimeType) {
+		width, height, err = thumbnail.Dimensions(filePath)
+		if err != nil {
+			log.Printf("Error measuring image %s: %v", filename, err)
+		} else if _, err := thumbnail.CreateAll(filePath, config.AppConfig.Media.ThumbDir(), filename, mimeType, width, maxPixels); err != nil && err != thumbnail.ErrAnimated {
+			log.Printf("Error creating thumbnails for %s: %v", filename, err)
+		}
+	}
+
+	media, err := models.CreateMedia(articleID, filename, header.Filename, relPath, mimeType, info.Size(), sum, width, height, user.Username)
 	if err != nil {
 		log.Printf("Error saving media record: %v", err)
-		os.Remove(filePath)
 		jsonError(w, "Failed to save media record.", http.StatusInternalServerError)
 		return
 	}
 
+	writeLinkedMediaJSON(w, media, articleID)
+}
+
+// writeLinkedMediaJSON answers an upload with media, first linking it to
+// the article it was uploaded for if it was recorded for another one.
+func writeLinkedMediaJSON(w http.ResponseWriter, media *models.Media, articleID *int) {
+	if articleID != nil && (media.ArticleID == nil || *media.ArticleID != *articleID) {
+		if err := models.LinkMedia(media.ID, *articleID); err != nil {
+			log.Printf("Error linking media %d to article %d: %v", media.ID, *articleID, err)
+			jsonError(w, "Failed to save media record.", http.StatusInternalServerError)
+			return
+		}
+	}
+	writeMediaJSON(w, media)
+}
+
+func writeMediaJSON(w http.ResponseWriter, media *models.Media) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"id":            media.ID,
@@ -82,7 +219,7 @@ func MediaUpload(w http.ResponseWriter, r *http.Request) {
 		"mime_type":     media.MimeType,
 		"file_size":     media.FileSize,
 		"embed_tag":     fmt.Sprintf("![%s](%s)", media.OriginalName, media.Filename),
-		"preview_url":   fmt.Sprintf("/media/%s", media.Filename),
+		"preview_url":   fmt.Sprintf("/media/%s?w=%d", media.Filename, thumbnail.Widths[0]),
 	})
 }
 
@@ -101,7 +238,70 @@ func ServeMedia(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	filePath := filepath.Join(config.AppConfig.Media.UploadDir, media.Filename)
+	// ?w=N serves the smallest variant at least N pixels wide.
+	if want, err := strconv.Atoi(r.URL.Query().Get("w")); err == nil && want > 0 {
+		if width := thumbnail.Fit(media.MimeType, media.Width, want); width > 0 {
+			serveThumbnail(w, r, media, width)
+			return
+		}
+	}
+
+	serveMediaFile(w, r, media)
+}
+
+// ServeThumbnail serves a resized variant of an image, creating and
+// caching it on first request. Only the standard widths are available, and
+// a width at least as large as the original serves the original.
+func ServeThumbnail(w http.ResponseWriter, r *http.Request) {
+	width, err := strconv.Atoi(r.PathValue("w"))
+	if err != nil || !slices.Contains(thumbnail.Widths, width) {
+		http.NotFound(w, r)
+		return
+	}
+
+	media, err := models.GetMediaByFilename(filepath.Base(r.PathValue("filename")))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !slices.Contains(thumbnail.Variants(media.MimeType, media.Width), width) {
+		serveMediaFile(w, r, media)
+		return
+	}
+	serveThumbnail(w, r, media, width)
+}
+
+func serveThumbnail(w http.ResponseWriter, r *http.Request, media *models.Media, width int) {
+	path := thumbnail.Path(config.AppConfig.Media.ThumbDir(), width, media.Filename)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		src := media.StoredPath(config.AppConfig.Media.UploadDir)
+		err = thumbnail.Create(src, path, media.MimeType, width, config.AppConfig.Media.PixelLimit())
+		if err == thumbnail.ErrAnimated || err == thumbnail.ErrTooLarge {
+			serveMediaFile(w, r, media)
+			return
+		}
+		if err == nil {
+			file, err = os.Open(path)
+		}
+	}
+	if err != nil {
+		log.Printf("Error opening thumbnail %s at %dpx: %v", media.Filename, width, err)
+		serveMediaFile(w, r, media)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", thumbnail.OutputType(media.MimeType))
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	setMediaSecurityHeaders(w)
+
+	http.ServeContent(w, r, media.Filename, media.CreatedAt, file)
+}
+
+func serveMediaFile(w http.ResponseWriter, r *http.Request, media *models.Media) {
+	filePath := media.StoredPath(config.AppConfig.Media.UploadDir)
 	file, err := os.Open(filePath)
 	if err != nil {
 		log.Printf("Error opening media file: %v", err)
@@ -112,10 +312,118 @@ func ServeMedia(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", media.MimeType)
 	w.Header().Set("Cache-Control", "public, max-age=86400")
+	setMediaSecurityHeaders(w)
 
 	http.ServeContent(w, r, media.Filename, media.CreatedAt, file)
 }
 
+// mediaCSP lets a media file opened on its own be displayed but nothing
+// else: no scripts, no requests and a sandboxed origin, in case a file
+// ever gets past the upload checks.
+const mediaCSP = "default-src 'none'; img-src 'self'; media-src 'self'; style-src 'unsafe-inline'; sandbox"
+
+func setMediaSecurityHeaders(w http.ResponseWriter) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", mediaCSP)
+}
+
+const mediaPageSize = 48
+
+// mediaTypeFilters are the type choices on the media library page.
+var mediaTypeFilters = []struct {
+	Value string
+	Label string
+}{
+	{"", "All"},
+	{models.MediaTypeImage, "Images"},
+	{models.MediaTypeVideo, "Videos"},
+}
+
+type mediaLink struct {
+	Label  string
+	URL    string
+	Active bool
+}
+
+// MediaLibrary lists uploaded files, searchable by original name and
+// filterable by type.
+func MediaLibrary(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	filter := models.MediaFilter{
+		Query: strings.TrimSpace(params.Get("q")),
+		Type:  params.Get("type"),
+		Limit: mediaPageSize,
+	}
+	page := 1
+	if n, err := strconv.Atoi(params.Get("page")); err == nil && n > 1 {
+		page = n
+	}
+	filter.Offset = (page - 1) * mediaPageSize
+
+	items, total, err := models.ListMedia(filter)
+	if err != nil {
+		log.Printf("Error listing media: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	link := func(key, value string) string {
+		next := url.Values{}
+		for k, v := range params {
+			next[k] = v
+		}
+		if key != "page" {
+			next.Del("page")
+		}
+		if value == "" {
+			next.Del(key)
+		} else {
+			next.Set(key, value)
+		}
+		if len(next) == 0 {
+			return "/media"
+		}
+		return "/media?" + next.Encode()
+	}
+
+	data := struct {
+		Query      string
+		Type       string
+		Types      []mediaLink
+		Items      []models.MediaItem
+		Total      int
+		Page       int
+		TotalPages int
+		PrevURL    string
+		NextURL    string
+	}{
+		Query:      filter.Query,
+		Type:       filter.Type,
+		Items:      items,
+		Total:      total,
+		Page:       page,
+		TotalPages: (total + mediaPageSize - 1) / mediaPageSize,
+	}
+	for _, t := range mediaTypeFilters {
+		data.Types = append(data.Types, mediaLink{
+			Label: t.Label, URL: link("type", t.Value), Active: t.Value == filter.Type,
+		})
+	}
+	if page > 1 {
+		data.PrevURL = link("page", strconv.Itoa(page-1))
+	}
+	if page < data.TotalPages {
+		data.NextURL = link("page", strconv.Itoa(page+1))
+	}
+
+	files := []string{
+		"./templates/base.tmpl.html",
+		"./templates/media.tmpl.html",
+	}
+
+	renderTemplate(w, r, files, data)
+}
+
 func jsonError(w http.ResponseWriter, message string, status int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -149,9 +457,3 @@ func extensionFromMIME(mimeType string) string {
 		return ""
 	}
 }
-
-func generateUUID() string {
-	b := make([]byte, 16)
-	rand.Read(b)
-	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
-}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+
+	"silic0n-wiki/middleware"
+	"silic0n-wiki/models"
+)
+
+type moveFormData struct {
+	Article       *models.ArticleWithCategory
+	NewSlug       string
+	LeaveRedirect bool
+	Reason        string
+	Aliases       []models.SlugAlias
+	IncomingLinks []models.Backlink
+	Errors        []string
+}
+
+func MoveArticlePage(w http.ResponseWriter, r *http.Request) {
+	slug := r.PathValue("slug")
+	article, err := models.GetArticleBySlug(slug)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Article not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error fetching article: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !requireEditable(w, r, article) {
+		return
+	}
+
+	aliases, err := models.GetSlugAliasesForArticle(article.ID)
+	if err != nil {
+		log.Printf("Error fetching slug aliases: %v", err)
+	}
+
+	incoming, err := models.GetLinksToSlug(article.Slug, article.ID)
+	if err != nil {
+		log.Printf("Error fetching incoming links: %v", err)
+	}
+
+	files := []string{
+		"./templates/base.tmpl.html",
+		"./templates/move.tmpl.html",
+	}
+
+	data := moveFormData{
+		Article:       article,
+		NewSlug:       article.Slug,
+		LeaveRedirect: true,
+		Aliases:       aliases,
+		IncomingLinks: incoming,
+	}
+
+	renderTemplate(w, r, files, data)
+}
+
+func MoveArticleSubmit(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+	slug := r.PathValue("slug")
+
+	article, err := models.GetArticleBySlug(slug)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Article not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error fetching article: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !requireEditable(w, r, article) {
+		return
+	}
+
+	r.ParseForm()
+	newSlug := strings.TrimSpace(r.FormValue("new_slug"))
+	leaveRedirect := r.FormValue("leave_redirect") == "on"
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	confirmDangling := r.FormValue("confirm_dangling") == "on"
+
+	incoming, err := models.GetLinksToSlug(article.Slug, article.ID)
+	if err != nil {
+		log.Printf("Error fetching incoming links: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	var errors []string
+	if newSlug == "" {
+		errors = append(errors, "New slug is required")
+	} else if models.Slugify(newSlug) != newSlug {
+		errors = append(errors, "Slug may only contain lowercase letters, numbers, and single hyphens (try \""+models.Slugify(newSlug)+"\")")
+	} else if newSlug == "new" {
+		errors = append(errors, "\"new\" is reserved and cannot be used as a slug")
+	} else if newSlug == article.Slug {
+		errors = append(errors, "The new slug is the same as the current one")
+	}
+	if !leaveRedirect && len(incoming) > 0 && !confirmDangling {
+		errors = append(errors, "Other pages link to this one; moving it without a redirect will break those links. Tick the confirmation box to move anyway")
+	}
+
+	if len(errors) == 0 {
+		_, err := models.MoveArticle(article.ID, newSlug, leaveRedirect, user.Username, reason)
+		if err == nil {
+			http.Redirect(w, r, "/wiki/"+newSlug, http.StatusSeeOther)
+			return
+		}
+		if err != models.ErrSlugTaken {
+			log.Printf("Error moving article: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		errors = append(errors, "Another article already uses that slug")
+	}
+
+	aliases, _ := models.GetSlugAliasesForArticle(article.ID)
+	files := []string{
+		"./templates/base.tmpl.html",
+		"./templates/move.tmpl.html",
+	}
+	data := moveFormData{
+		Article:       article,
+		NewSlug:       newSlug,
+		LeaveRedirect: leaveRedirect,
+		Reason:        reason,
+		Aliases:       aliases,
+		IncomingLinks: incoming,
+		Errors:        errors,
+	}
+	renderTemplate(w, r, files, data)
+}
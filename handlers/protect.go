@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"silic0n-wiki/middleware"
+	"silic0n-wiki/models"
+)
+
+type protectionDuration struct {
+	Value string
+	Label string
+}
+
+// protectionDurations are the expiry choices on the protect form. An empty
+// value protects indefinitely.
+var protectionDurations = []protectionDuration{
+	{"", "Indefinitely"},
+	{"24h", "1 day"},
+	{"168h", "1 week"},
+	{"720h", "1 month"},
+	{"2160h", "3 months"},
+	{"8760h", "1 year"},
+}
+
+type protectFormData struct {
+	Article   *models.ArticleWithCategory
+	Levels    []models.ProtectionLevel
+	Durations []protectionDuration
+	Level     models.ProtectionLevel
+	Duration  string
+	Reason    string
+	Log       []models.ProtectionLogEntry
+	Errors    []string
+}
+
+// requireEditable writes a 403 and returns false if the current user may
+// not edit the article at its protection level.
+func requireEditable(w http.ResponseWriter, r *http.Request, article *models.ArticleWithCategory) bool {
+	if middleware.GetUser(r).CanEdit(article.Protection) {
+		return true
+	}
+	http.Error(w, "Forbidden - this page is protected ("+article.Protection.Current().Label()+")", http.StatusForbidden)
+	return false
+}
+
+func ProtectArticlePage(w http.ResponseWriter, r *http.Request) {
+	slug := r.PathValue("slug")
+	article, err := models.GetArticleBySlug(slug)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Article not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error fetching article: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !requireEditable(w, r, article) {
+		return
+	}
+
+	entries, err := models.GetProtectionLog(article.ID)
+	if err != nil {
+		log.Printf("Error fetching protection log: %v", err)
+	}
+
+	renderProtectForm(w, r, protectFormData{
+		Article: article,
+		Level:   article.Protection.Current(),
+		Log:     entries,
+	})
+}
+
+func ProtectArticleSubmit(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+	slug := r.PathValue("slug")
+
+	article, err := models.GetArticleBySlug(slug)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Article not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error fetching article: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !requireEditable(w, r, article) {
+		return
+	}
+
+	r.ParseForm()
+	level := models.ProtectionLevel(r.FormValue("level"))
+	duration := r.FormValue("duration")
+	reason := strings.TrimSpace(r.FormValue("reason"))
+
+	var errors []string
+	if !level.Valid() {
+		errors = append(errors, "Choose a protection level")
+	} else if !user.CanProtect(article.Protection, level) {
+		errors = append(errors, "You do not have permission to set this protection level")
+	}
+	var expiresAt time.Time
+	if duration != "" && level != models.ProtectionNone {
+		d, err := time.ParseDuration(duration)
+		if err != nil || d <= 0 {
+			errors = append(errors, "Choose how long the protection lasts")
+		} else {
+			expiresAt = time.Now().Add(d)
+		}
+	}
+	if reason == "" {
+		errors = append(errors, "A reason is required")
+	}
+
+	if len(errors) == 0 {
+		err := models.SetProtection(article.ID, level, expiresAt, user.Username, reason)
+		if err == nil {
+			http.Redirect(w, r, "/wiki/"+article.Slug, http.StatusSeeOther)
+			return
+		}
+		log.Printf("Error setting protection: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	entries, _ := models.GetProtectionLog(article.ID)
+	renderProtectForm(w, r, protectFormData{
+		Article:  article,
+		Level:    level,
+		Duration: duration,
+		Reason:   reason,
+		Log:      entries,
+		Errors:   errors,
+	})
+}
+
+func renderProtectForm(w http.ResponseWriter, r *http.Request, data protectFormData) {
+	files := []string{
+		"./templates/base.tmpl.html",
+		"./templates/protect.tmpl.html",
+	}
+	data.Levels = models.ProtectionLevels
+	data.Durations = protectionDurations
+	renderTemplate(w, r, files, data)
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"silic0n-wiki/middleware"
+	"silic0n-wiki/models"
+)
+
+func ArticleHistory(w http.ResponseWriter, r *http.Request) {
+	slug := r.PathValue("slug")
+	article, err := models.GetArticleBySlug(slug)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Article not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error fetching article: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	revisions, err := models.GetRevisionsForArticle(article.ID)
+	if err != nil {
+		log.Printf("Error fetching revisions: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	files := []string{
+		"./templates/base.tmpl.html",
+		"./templates/history.tmpl.html",
+	}
+
+	data := struct {
+		Article   *models.ArticleWithCategory
+		Revisions []models.RevisionSummary
+	}{
+		Article:   article,
+		Revisions: revisions,
+	}
+
+	renderTemplate(w, r, files, data)
+}
+
+func ArticleDiff(w http.ResponseWriter, r *http.Request) {
+	slug := r.PathValue("slug")
+	article, err := models.GetArticleBySlug(slug)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Article not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error fetching article: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	toID, _ := strconv.Atoi(r.URL.Query().Get("to"))
+	if toID == 0 {
+		toID, err = models.GetLatestRevisionID(article.ID)
+		if err != nil {
+			log.Printf("Error fetching latest revision: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	fromID, _ := strconv.Atoi(r.URL.Query().Get("from"))
+	if fromID == 0 {
+		fromID, err = models.GetPreviousRevisionID(article.ID, toID)
+		if err != nil {
+			log.Printf("Error fetching previous revision: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if fromID == 0 {
+			fromID = toID
+		}
+	}
+	if fromID > toID {
+		fromID, toID = toID, fromID
+	}
+
+	revDiff, err := models.DiffRevisions(fromID, toID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Revision not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error diffing revisions: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if revDiff.From.ArticleID != article.ID {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+
+	files := []string{
+		"./templates/base.tmpl.html",
+		"./templates/diff.tmpl.html",
+	}
+
+	data := struct {
+		Article *models.ArticleWithCategory
+		Diff    *models.RevisionDiff
+	}{
+		Article: article,
+		Diff:    revDiff,
+	}
+
+	renderTemplate(w, r, files, data)
+}
+
+func RevertArticle(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+	slug := r.PathValue("slug")
+
+	article, err := models.GetArticleBySlug(slug)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Article not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error fetching article: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !requireEditable(w, r, article) {
+		return
+	}
+
+	revisionID, err := strconv.Atoi(r.PathValue("revisionID"))
+	if err != nil {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+
+	updated, err := models.RevertArticle(article.ID, revisionID, user.Username)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Revision not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error reverting article: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/wiki/"+updated.Slug, http.StatusSeeOther)
+}
+
+func RollbackArticle(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+	slug := r.PathValue("slug")
+
+	article, err := models.GetArticleBySlug(slug)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Article not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error fetching article: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if !requireEditable(w, r, article) {
+		return
+	}
+
+	username := strings.TrimSpace(r.FormValue("user"))
+	if username == "" {
+		http.Error(w, "User is required", http.StatusBadRequest)
+		return
+	}
+
+	updated, _, err := models.RollbackEdits(article.ID, username, user.Username)
+	if err != nil {
+		if err == models.ErrNothingToRollback {
+			http.Error(w, "Nothing to roll back: "+err.Error(), http.StatusConflict)
+			return
+		}
+		if err == models.ErrEditConflict {
+			http.Error(w, "The article was edited while rolling back; reload its history and try again.", http.StatusConflict)
+			return
+		}
+		log.Printf("Error rolling back article: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/wiki/"+updated.Slug+"/history", http.StatusSeeOther)
+}
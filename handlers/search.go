@@ -2,23 +2,32 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
+	"html/template"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"silic0n-wiki/models"
+	"silic0n-wiki/search"
 )
 
 type SearchResult struct {
 	Slug        string `json:"slug"`
 	Title       string `json:"title"`
 	Description string `json:"description"`
+	URL         string `json:"url"`
 }
 
-func truncate(s string, maxLen int) string {
-	if len(s) <= maxLen {
-		return s
-	}
-	return s[:maxLen] + "..."
+// highlightSnippet escapes a search snippet and turns its highlight markers
+// into <mark> tags.
+func highlightSnippet(snippet string) template.HTML {
+	escaped := template.HTMLEscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, search.HighlightStart, "<mark>")
+	escaped = strings.ReplaceAll(escaped, search.HighlightStop, "</mark>")
+	return template.HTML(escaped)
 }
 
 func Search(w http.ResponseWriter, r *http.Request) {
@@ -29,22 +38,240 @@ func Search(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	articles, err := models.SearchArticles(query)
+	found, err := search.Default.Query(search.Query{Text: query, Limit: 10})
 	if err != nil {
 		log.Printf("Error searching articles: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	results := make([]SearchResult, len(articles))
-	for i, a := range articles {
+	// Suggestions are fetched on every keystroke, so they are not logged:
+	// the analytics would fill up with every prefix of every title.
+	results := make([]SearchResult, len(found.Hits))
+	for i, h := range found.Hits {
 		results[i] = SearchResult{
-			Slug:        a.Slug,
-			Title:       a.Title,
-			Description: truncate(a.Content, 100),
+			Slug:        h.Slug,
+			Title:       h.Title,
+			Description: string(highlightSnippet(h.Snippet)),
+			URL:         searchClickURL(0, h.Slug),
 		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(results)
 }
+
+const searchPageSize = 20
+
+type searchHitView struct {
+	search.Hit
+	Highlighted template.HTML
+	ClickURL    string
+}
+
+// searchLink is a facet, sort option or page link on the search page.
+type searchLink struct {
+	Label  string
+	Count  int
+	URL    string
+	Active bool
+}
+
+type searchPageData struct {
+	Query         string
+	Category      string
+	Tag           string
+	Sort          string
+	Total         int
+	Hits          []searchHitView
+	Categories    []searchLink
+	Tags          []searchLink
+	Sorts         []searchLink
+	Page          int
+	TotalPages    int
+	PrevURL       string
+	NextURL       string
+	ClearURL      string
+	CreateTitle   string
+	Suggestion    string
+	SuggestionURL string
+}
+
+func SearchPage(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	data := searchPageData{
+		Query:    strings.TrimSpace(params.Get("q")),
+		Category: params.Get("category"),
+		Tag:      params.Get("tag"),
+		Sort:     params.Get("sort"),
+		Page:     1,
+	}
+	if data.Sort != search.SortTitle && data.Sort != search.SortUpdated {
+		data.Sort = search.SortRelevance
+	}
+	if page, err := strconv.Atoi(params.Get("page")); err == nil && page > 1 {
+		data.Page = page
+	}
+
+	files := []string{
+		"./templates/base.tmpl.html",
+		"./templates/search.tmpl.html",
+	}
+
+	if data.Query == "" {
+		renderTemplate(w, r, files, data)
+		return
+	}
+
+	results, err := search.Default.Query(search.Query{
+		Text:     data.Query,
+		Category: data.Category,
+		Tag:      data.Tag,
+		Sort:     data.Sort,
+		Limit:    searchPageSize,
+		Offset:   (data.Page - 1) * searchPageSize,
+	})
+	if err != nil {
+		log.Printf("Error searching articles: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// Paging through results or narrowing them by a facet is not a new
+	// search, so only the first unfiltered page is logged.
+	var queryID int
+	if data.Page == 1 && data.Category == "" && data.Tag == "" {
+		queryID = logSearch(data.Query, models.SearchSourcePage, results.Total)
+	}
+
+	data.Total = results.Total
+	data.TotalPages = (results.Total + searchPageSize - 1) / searchPageSize
+	for _, h := range results.Hits {
+		data.Hits = append(data.Hits, searchHitView{
+			Hit:         h,
+			Highlighted: highlightSnippet(h.Snippet),
+			ClickURL:    searchClickURL(queryID, h.Slug),
+		})
+	}
+
+	link := func(key, value string) string {
+		return searchURL(params, key, value)
+	}
+	for _, f := range results.Categories {
+		value := f.Slug
+		if value == data.Category {
+			value = ""
+		}
+		data.Categories = append(data.Categories, searchLink{
+			Label: f.Name, Count: f.Count, URL: link("category", value), Active: f.Slug == data.Category,
+		})
+	}
+	for _, f := range results.Tags {
+		value := f.Slug
+		if value == data.Tag {
+			value = ""
+		}
+		data.Tags = append(data.Tags, searchLink{
+			Label: f.Name, Count: f.Count, URL: link("tag", value), Active: f.Slug == data.Tag,
+		})
+	}
+	for _, s := range []struct{ value, label string }{
+		{search.SortRelevance, "Relevance"},
+		{search.SortTitle, "Title"},
+		{search.SortUpdated, "Recently updated"},
+	} {
+		data.Sorts = append(data.Sorts, searchLink{
+			Label: s.label, URL: link("sort", s.value), Active: s.value == data.Sort,
+		})
+	}
+	if data.Page > 1 {
+		data.PrevURL = link("page", strconv.Itoa(data.Page-1))
+	}
+	if data.Page < data.TotalPages {
+		data.NextURL = link("page", strconv.Itoa(data.Page+1))
+	}
+	if data.Category != "" || data.Tag != "" {
+		data.ClearURL = "/search?q=" + url.QueryEscape(data.Query)
+	}
+
+	// Hits are ordered full-text matches first, so an inexact first hit
+	// means the query only matched loosely, or not at all.
+	if data.Page == 1 && (len(results.Hits) == 0 || !results.Hits[0].Exact) {
+		suggestions, err := models.SuggestArticles(data.Query, 1)
+		if err != nil {
+			log.Printf("Error fetching search suggestions: %v", err)
+		} else if len(suggestions) > 0 && !strings.EqualFold(suggestions[0].Title, data.Query) {
+			data.Suggestion = suggestions[0].Title
+			data.SuggestionURL = link("q", suggestions[0].Title)
+		}
+	}
+
+	// Offer to create the page unless an article already has this title.
+	if slug := models.Slugify(data.Query); slug != "" && slug != "new" {
+		existing, err := models.ExistingSlugs([]string{slug})
+		if err != nil {
+			log.Printf("Error checking for exact match: %v", err)
+		} else if !existing[slug] {
+			data.CreateTitle = data.Query
+		}
+	}
+
+	renderTemplate(w, r, files, data)
+}
+
+// searchURL returns the search page URL for params with key set to value,
+// or removed if value is empty. Changing anything but the page resets the
+// page to the first.
+func searchURL(params url.Values, key, value string) string {
+	next := url.Values{}
+	for k, v := range params {
+		next[k] = v
+	}
+	if key != "page" {
+		next.Del("page")
+	}
+	if value == "" {
+		next.Del(key)
+	} else {
+		next.Set(key, value)
+	}
+	return "/search?" + next.Encode()
+}
+
+// logSearch records a search for the analytics report and returns its id,
+// or 0 if it could not be logged. Logging never fails the search itself.
+func logSearch(query, source string, resultCount int) int {
+	id, err := models.LogSearch(query, source, resultCount)
+	if err != nil {
+		log.Printf("Error logging search query: %v", err)
+		return 0
+	}
+	return id
+}
+
+// searchClickURL returns the link for a search hit, going through
+// SearchClick when the search was logged so the click is recorded.
+func searchClickURL(queryID int, slug string) string {
+	if queryID == 0 {
+		return "/wiki/" + url.PathEscape(slug)
+	}
+	return fmt.Sprintf("/search/click/%d?slug=%s", queryID, url.QueryEscape(slug))
+}
+
+// SearchClick records that a logged search led to an article and redirects
+// to it.
+func SearchClick(w http.ResponseWriter, r *http.Request) {
+	slug := r.URL.Query().Get("slug")
+	if slug == "" || models.Slugify(slug) != slug {
+		http.Error(w, "Invalid article", http.StatusBadRequest)
+		return
+	}
+
+	if id, err := strconv.Atoi(r.PathValue("id")); err == nil {
+		if err := models.RecordSearchClick(id, slug); err != nil {
+			log.Printf("Error recording search click: %v", err)
+		}
+	}
+
+	http.Redirect(w, r, "/wiki/"+slug, http.StatusFound)
+}
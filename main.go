@@ -1,32 +1,91 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log"
 	"os"
 
+	"silic0n-wiki/assets"
 	"silic0n-wiki/config"
 	"silic0n-wiki/database"
-	"silic0n-wiki/routes"
 )
 
+const usage = `Usage: silic0n-wiki [-config path] [-dev] <command> [arguments]
+
+Commands:
+  serve                                  run migrations and start the web server (default)
+  migrate [up]                           apply pending database migrations
+  migrate down [N]                       roll back the last N migrations (default 1) using their down scripts
+  migrate status                         list migrations and whether they are applied or were edited
+  reindex                                rebuild the search index; stop a server using the embedded
+                                         backend first, as it only reads the index file at startup
+  user create [flags] USERNAME EMAIL     create an account; flags: -role ROLE, -password-stdin
+  user set-role USERNAME ROLE            change an account's role (reader, editor, moderator, admin)
+  user reset-password [flags] USERNAME   set a new password and end all sessions; flags: -password-stdin
+  user disable USERNAME                  block an account from logging in and end its sessions
+  user enable USERNAME                   lift a block set by user disable
+  sessions purge                         delete expired sessions
+  media migrate                          move files uploaded before content-addressed storage into it
+
+Without -password-stdin a random password is generated and printed. Accounts
+registered on the site are editors, and migrations never promote anyone;
+create the first admin with "user create -role admin USERNAME EMAIL" or
+promote an existing account with "user set-role USERNAME admin".
+
+Templates, static files and migrations are embedded in the binary. With -dev,
+or server.assets_dir set in the config, they are read from disk instead.
+`
+
 func main() {
-	if err := config.Load("config.yaml"); err != nil {
+	configPath := flag.String("config", "config.yaml", "path to the configuration file")
+	dev := flag.Bool("dev", false, "read templates, static files and migrations from the working directory")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+
+	if err := config.Load(*configPath); err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
+	assetsDir := config.AppConfig.Server.AssetsDir
+	if *dev && assetsDir == "" {
+		assetsDir = "."
+	}
+	assets.Use(embeddedAssets, assetsDir)
+
 	if err := database.Connect(); err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
 	defer database.Close()
 
-	if err := database.RunMigrations("./database/migrations"); err != nil {
-		log.Fatalf("Failed to run migrations: %v", err)
+	command, args := "serve", flag.Args()
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
 	}
 
-	if err := os.MkdirAll(config.AppConfig.Media.UploadDir, 0755); err != nil {
-		log.Fatalf("Failed to create upload directory: %v", err)
+	var err error
+	switch command {
+	case "serve":
+		err = runServe()
+	case "migrate":
+		err = runMigrate(args)
+	case "reindex":
+		err = runReindex()
+	case "user":
+		err = runUser(args)
+	case "sessions":
+		err = runSessions(args)
+	case "media":
+		err = runMedia(args)
+	default:
+		err = errUsage
 	}
 
-	log.Printf("Server starting on port %d", config.AppConfig.Server.Port)
-	routes.StartRouter()
+	if err == errUsage {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,527 @@
+package markup
+
+import (
+	"strconv"
+	"strings"
+)
+
+type blockKind int
+
+const (
+	paragraphBlock blockKind = iota
+	headingBlock
+	codeBlock
+	quoteBlock
+	listBlock
+	itemBlock
+	tableBlock
+	ruleBlock
+)
+
+type block struct {
+	kind     blockKind
+	text     string // inline source of paragraphs and headings
+	level    int    // heading level
+	id       string // heading anchor
+	section  int    // section number of top-level headings
+	line     int    // index of the block's first line
+	info     string // fenced code info string
+	code     []string
+	ordered  bool
+	start    int
+	loose    bool
+	children []*block
+	head     []string
+	align    []string
+	rows     [][]string
+}
+
+func splitLines(source string) []string {
+	source = strings.ReplaceAll(source, "\r\n", "\n")
+	source = strings.ReplaceAll(source, "\r", "\n")
+	lines := strings.Split(source, "\n")
+	for i, line := range lines {
+		lines[i] = expandIndentTabs(line)
+	}
+	return lines
+}
+
+// expandIndentTabs replaces tabs in a line's leading whitespace with spaces
+// up to the next multiple of four columns.
+func expandIndentTabs(line string) string {
+	if !strings.Contains(line, "\t") {
+		return line
+	}
+	var b strings.Builder
+	col := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case ' ':
+			b.WriteByte(' ')
+			col++
+		case '\t':
+			n := 4 - col%4
+			b.WriteString(strings.Repeat(" ", n))
+			col += n
+		default:
+			b.WriteString(line[i:])
+			return b.String()
+		}
+	}
+	return b.String()
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+func indentOf(line string) int {
+	n := 0
+	for n < len(line) && line[n] == ' ' {
+		n++
+	}
+	return n
+}
+
+func stripIndent(line string, n int) string {
+	i := 0
+	for i < n && i < len(line) && line[i] == ' ' {
+		i++
+	}
+	return line[i:]
+}
+
+func parseBlocks(lines []string, depth int) []*block {
+	var blocks []*block
+	for i := 0; i < len(lines); {
+		if isBlank(lines[i]) {
+			i++
+			continue
+		}
+		b, next := parseBlock(lines, i, depth)
+		b.line = i
+		blocks = append(blocks, b)
+		i = next
+	}
+	return blocks
+}
+
+// parseBlock parses the block starting at lines[i], which is not blank, and
+// returns it with the index of the first line after it.
+func parseBlock(lines []string, i, depth int) (*block, int) {
+	line := lines[i]
+
+	if fence, n, info, indent, ok := fenceOpen(line); ok {
+		b := &block{kind: codeBlock, info: info}
+		i++
+		for i < len(lines) && !fenceClose(lines[i], fence, n) {
+			b.code = append(b.code, stripIndent(lines[i], indent))
+			i++
+		}
+		return b, i + 1
+	}
+
+	if level, text, ok := atxHeading(line); ok {
+		return &block{kind: headingBlock, level: level, text: text}, i + 1
+	}
+
+	if isThematicBreak(line) {
+		return &block{kind: ruleBlock}, i + 1
+	}
+
+	if depth < maxDepth && isQuoteStart(line) {
+		var inner []string
+		for i < len(lines) {
+			l := lines[i]
+			if isQuoteStart(l) {
+				inner = append(inner, stripQuote(l))
+			} else if !isBlank(l) && len(inner) > 0 && !isBlank(inner[len(inner)-1]) && !startsBlock(l) {
+				inner = append(inner, l)
+			} else {
+				break
+			}
+			i++
+		}
+		return &block{kind: quoteBlock, children: parseBlocks(inner, depth+1)}, i
+	}
+
+	if depth < maxDepth {
+		if _, ok := parseListMarker(line); ok {
+			return parseList(lines, i, depth)
+		}
+	}
+
+	if isTableStart(lines, i) {
+		return parseTable(lines, i)
+	}
+
+	para := []string{strings.TrimLeft(line, " ")}
+	i++
+	heading := 0
+	for i < len(lines) {
+		l := lines[i]
+		if isBlank(l) {
+			break
+		}
+		if heading = setextLevel(l); heading > 0 {
+			i++
+			break
+		}
+		if interruptsParagraph(lines, i) {
+			break
+		}
+		para = append(para, strings.TrimLeft(l, " "))
+		i++
+	}
+	text := strings.TrimRight(strings.Join(para, "\n"), " ")
+	if heading > 0 {
+		return &block{kind: headingBlock, level: heading, text: text}, i
+	}
+	return &block{kind: paragraphBlock, text: text}, i
+}
+
+// fenceOpen recognises the opening line of a fenced code block.
+func fenceOpen(line string) (fence byte, n int, info string, indent int, ok bool) {
+	indent = indentOf(line)
+	if indent > 3 || indent >= len(line) {
+		return 0, 0, "", 0, false
+	}
+	rest := line[indent:]
+	fence = rest[0]
+	if fence != '`' && fence != '~' {
+		return 0, 0, "", 0, false
+	}
+	for n < len(rest) && rest[n] == fence {
+		n++
+	}
+	if n < 3 {
+		return 0, 0, "", 0, false
+	}
+	info = strings.TrimSpace(rest[n:])
+	if fence == '`' && strings.Contains(info, "`") {
+		return 0, 0, "", 0, false
+	}
+	return fence, n, info, indent, true
+}
+
+func fenceClose(line string, fence byte, n int) bool {
+	indent := indentOf(line)
+	if indent > 3 {
+		return false
+	}
+	rest := strings.TrimRight(line[indent:], " ")
+	if len(rest) < n {
+		return false
+	}
+	for i := 0; i < len(rest); i++ {
+		if rest[i] != fence {
+			return false
+		}
+	}
+	return true
+}
+
+func atxHeading(line string) (int, string, bool) {
+	indent := indentOf(line)
+	if indent > 3 {
+		return 0, "", false
+	}
+	rest := line[indent:]
+	level := 0
+	for level < len(rest) && rest[level] == '#' {
+		level++
+	}
+	if level == 0 || level > 6 {
+		return 0, "", false
+	}
+	if level < len(rest) && rest[level] != ' ' {
+		return 0, "", false
+	}
+
+	text := strings.TrimSpace(rest[level:])
+	// Drop an optional closing sequence of #s.
+	trimmed := strings.TrimRight(text, "#")
+	if trimmed == "" {
+		text = ""
+	} else if trimmed != text && strings.HasSuffix(trimmed, " ") {
+		text = strings.TrimSpace(trimmed)
+	}
+	return level, text, true
+}
+
+func setextLevel(line string) int {
+	if indentOf(line) > 3 {
+		return 0
+	}
+	s := strings.TrimSpace(line)
+	if s == "" {
+		return 0
+	}
+	if strings.Trim(s, "=") == "" {
+		return 1
+	}
+	if strings.Trim(s, "-") == "" {
+		return 2
+	}
+	return 0
+}
+
+func isThematicBreak(line string) bool {
+	if indentOf(line) > 3 {
+		return false
+	}
+	var marker byte
+	count := 0
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch c {
+		case ' ':
+			continue
+		case '-', '*', '_':
+			if marker != 0 && c != marker {
+				return false
+			}
+			marker = c
+			count++
+		default:
+			return false
+		}
+	}
+	return count >= 3
+}
+
+func isQuoteStart(line string) bool {
+	indent := indentOf(line)
+	return indent <= 3 && indent < len(line) && line[indent] == '>'
+}
+
+func stripQuote(line string) string {
+	rest := line[indentOf(line)+1:]
+	return strings.TrimPrefix(rest, " ")
+}
+
+type listMarker struct {
+	ordered bool
+	char    byte
+	start   int
+	indent  int // column where item content starts
+	content string
+}
+
+func parseListMarker(line string) (listMarker, bool) {
+	var m listMarker
+	indent := indentOf(line)
+	if indent > 3 || indent >= len(line) {
+		return m, false
+	}
+	rest := line[indent:]
+
+	width := 0
+	switch rest[0] {
+	case '-', '*', '+':
+		m.char = rest[0]
+		width = 1
+	default:
+		for width < len(rest) && width < 9 && rest[width] >= '0' && rest[width] <= '9' {
+			width++
+		}
+		if width == 0 || width >= len(rest) || (rest[width] != '.' && rest[width] != ')') {
+			return m, false
+		}
+		m.ordered = true
+		m.char = rest[width]
+		m.start, _ = strconv.Atoi(rest[:width])
+		width++
+	}
+
+	after := rest[width:]
+	if isBlank(after) {
+		m.indent = indent + width + 1
+		return m, true
+	}
+	if after[0] != ' ' {
+		return m, false
+	}
+	spaces := indentOf(after)
+	if spaces > 4 {
+		spaces = 1
+	}
+	m.indent = indent + width + spaces
+	m.content = line[m.indent:]
+	return m, true
+}
+
+// startsBlock reports whether line would open a new block, which stops lazy
+// continuation of paragraphs inside quotes and list items.
+func startsBlock(line string) bool {
+	if _, _, _, _, ok := fenceOpen(line); ok {
+		return true
+	}
+	if _, _, ok := atxHeading(line); ok {
+		return true
+	}
+	if isThematicBreak(line) || isQuoteStart(line) {
+		return true
+	}
+	_, ok := parseListMarker(line)
+	return ok
+}
+
+func interruptsParagraph(lines []string, i int) bool {
+	line := lines[i]
+	if _, _, _, _, ok := fenceOpen(line); ok {
+		return true
+	}
+	if _, _, ok := atxHeading(line); ok {
+		return true
+	}
+	if isThematicBreak(line) || isQuoteStart(line) {
+		return true
+	}
+	if m, ok := parseListMarker(line); ok && m.content != "" && (!m.ordered || m.start == 1) {
+		return true
+	}
+	return isTableStart(lines, i)
+}
+
+func parseList(lines []string, i, depth int) (*block, int) {
+	first, _ := parseListMarker(lines[i])
+	list := &block{kind: listBlock, ordered: first.ordered, start: first.start}
+
+	blankBefore := false
+	for i < len(lines) {
+		m, ok := parseListMarker(lines[i])
+		if !ok || m.ordered != first.ordered || m.char != first.char || isThematicBreak(lines[i]) {
+			break
+		}
+		if blankBefore {
+			list.loose = true
+		}
+
+		item := []string{m.content}
+		blankInside := false
+		i++
+		for i < len(lines) {
+			l := lines[i]
+			if isBlank(l) {
+				j := i
+				for j < len(lines) && isBlank(lines[j]) {
+					j++
+				}
+				if j < len(lines) && indentOf(lines[j]) >= m.indent && !(len(item) == 1 && item[0] == "") {
+					for ; i < j; i++ {
+						item = append(item, "")
+					}
+					blankInside = true
+					continue
+				}
+				break
+			}
+			if indentOf(l) >= m.indent {
+				item = append(item, l[m.indent:])
+				i++
+				continue
+			}
+			if _, isMarker := parseListMarker(l); isMarker {
+				break
+			}
+			if !isBlank(item[len(item)-1]) && !startsBlock(l) {
+				item = append(item, strings.TrimLeft(l, " "))
+				i++
+				continue
+			}
+			break
+		}
+
+		blankBefore = false
+		for i < len(lines) && isBlank(lines[i]) {
+			i++
+			blankBefore = true
+		}
+
+		children := parseBlocks(item, depth+1)
+		if blankInside && len(children) > 1 {
+			list.loose = true
+		}
+		list.children = append(list.children, &block{kind: itemBlock, children: children})
+	}
+	return list, i
+}
+
+func isTableStart(lines []string, i int) bool {
+	if i+1 >= len(lines) || !strings.Contains(lines[i], "|") || indentOf(lines[i]) > 3 {
+		return false
+	}
+	align, ok := parseDelimiterRow(lines[i+1])
+	return ok && len(align) == len(splitTableRow(lines[i]))
+}
+
+func parseDelimiterRow(line string) ([]string, bool) {
+	if !strings.Contains(line, "|") || indentOf(line) > 3 {
+		return nil, false
+	}
+	var align []string
+	for _, cell := range splitTableRow(line) {
+		c := strings.TrimSpace(cell)
+		left := strings.HasPrefix(c, ":")
+		right := strings.HasSuffix(c, ":")
+		dashes := strings.Trim(c, ":")
+		if dashes == "" || strings.Trim(dashes, "-") != "" {
+			return nil, false
+		}
+		switch {
+		case left && right:
+			align = append(align, "center")
+		case left:
+			align = append(align, "left")
+		case right:
+			align = append(align, "right")
+		default:
+			align = append(align, "")
+		}
+	}
+	return align, true
+}
+
+// splitTableRow splits a table row on unescaped pipes, dropping the optional
+// leading and trailing pipe.
+func splitTableRow(line string) []string {
+	s := strings.TrimSpace(line)
+	s = strings.TrimPrefix(s, "|")
+	if strings.HasSuffix(s, "|") && !strings.HasSuffix(s, `\|`) {
+		s = s[:len(s)-1]
+	}
+
+	var cells []string
+	var cell strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && s[i+1] == '|' {
+			cell.WriteByte('|')
+			i++
+			continue
+		}
+		if s[i] == '|' {
+			cells = append(cells, strings.TrimSpace(cell.String()))
+			cell.Reset()
+			continue
+		}
+		cell.WriteByte(s[i])
+	}
+	return append(cells, strings.TrimSpace(cell.String()))
+}
+
+func parseTable(lines []string, i int) (*block, int) {
+	head := splitTableRow(lines[i])
+	align, _ := parseDelimiterRow(lines[i+1])
+	table := &block{kind: tableBlock, head: head, align: align}
+
+	i += 2
+	for i < len(lines) && !isBlank(lines[i]) && !startsBlock(lines[i]) {
+		cells := splitTableRow(lines[i])
+		row := make([]string, len(head))
+		copy(row, cells)
+		table.rows = append(table.rows, row)
+		i++
+	}
+	return table, i
+}
@@ -0,0 +1,543 @@
+package markup
+
+import (
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// inlineNode is either a piece of finished HTML or a run of emphasis
+// delimiters that may later be turned into tags.
+type inlineNode struct {
+	html      string
+	delim     byte
+	count     int
+	origCount int
+	canOpen   bool
+	canClose  bool
+	opens     string
+	closes    string
+}
+
+type inlineParser struct {
+	r       *renderer
+	src     string
+	pos     int
+	nodes   []*inlineNode
+	text    strings.Builder
+	noLinks bool
+
+	brackets map[int]int
+	nested   map[int]bool
+	ticks    map[int][]int
+}
+
+// maxLinkTail bounds how far past a link's closing bracket its destination
+// and title are looked for, so a long run of unterminated links cannot make
+// parsing quadratic.
+const maxLinkTail = 4096
+
+var autolinkRegex = regexp.MustCompile(`^<((?:https?|mailto):[^<>\s]+|[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,})>`)
+
+// inline renders a span of inline Markdown to HTML.
+func (r *renderer) inline(src string) string {
+	return r.inlineWith(src, false)
+}
+
+func (r *renderer) inlineWith(src string, noLinks bool) string {
+	p := &inlineParser{r: r, src: src, noLinks: noLinks}
+	p.scanBrackets()
+	p.scanBackticks()
+	p.parse()
+	p.processEmphasis()
+
+	var b strings.Builder
+	for _, n := range p.nodes {
+		if n.delim != 0 {
+			b.WriteString(n.closes)
+			b.WriteString(strings.Repeat(string(n.delim), n.count))
+			b.WriteString(n.opens)
+			continue
+		}
+		b.WriteString(n.html)
+	}
+	return b.String()
+}
+
+func (p *inlineParser) flushText() {
+	if p.text.Len() == 0 {
+		return
+	}
+	p.nodes = append(p.nodes, &inlineNode{html: html.EscapeString(p.text.String())})
+	p.text.Reset()
+}
+
+func (p *inlineParser) emit(h string) {
+	p.flushText()
+	p.nodes = append(p.nodes, &inlineNode{html: h})
+}
+
+func (p *inlineParser) parse() {
+	src := p.src
+	for p.pos < len(src) {
+		c := src[p.pos]
+		switch {
+		case c == '\\':
+			p.parseEscape()
+		case c == '`':
+			p.parseCodeSpan()
+		case c == '*' || c == '_' || c == '~':
+			p.parseDelimiterRun()
+		case c == '!' && p.pos+1 < len(src) && src[p.pos+1] == '[':
+			if !p.parseMedia() {
+				p.text.WriteByte('!')
+				p.pos++
+			}
+		case c == '[' && !p.noLinks && p.pos+1 < len(src) && src[p.pos+1] == '[' && p.parseWikiLink():
+		case c == '[':
+			if p.noLinks || !p.parseLink() {
+				p.text.WriteByte('[')
+				p.pos++
+			}
+		case c == '<':
+			if p.noLinks || !p.parseAutolink() {
+				p.text.WriteByte('<')
+				p.pos++
+			}
+		case c == '\n':
+			p.parseLineBreak()
+		case c == 'h' && !p.noLinks && p.atWordStart() &&
+			(strings.HasPrefix(src[p.pos:], "http://") || strings.HasPrefix(src[p.pos:], "https://")):
+			if !p.parseBareURL() {
+				p.text.WriteByte(c)
+				p.pos++
+			}
+		default:
+			p.text.WriteByte(c)
+			p.pos++
+		}
+	}
+	p.flushText()
+}
+
+func (p *inlineParser) parseEscape() {
+	if p.pos+1 < len(p.src) {
+		next := p.src[p.pos+1]
+		if next == '\n' {
+			p.emit("<br>\n")
+			p.pos += 2
+			return
+		}
+		if isASCIIPunct(next) {
+			p.text.WriteByte(next)
+			p.pos += 2
+			return
+		}
+	}
+	p.text.WriteByte('\\')
+	p.pos++
+}
+
+func (p *inlineParser) parseLineBreak() {
+	raw := p.text.String()
+	trimmed := strings.TrimRight(raw, " ")
+	hard := len(raw)-len(trimmed) >= 2
+	p.text.Reset()
+	p.text.WriteString(trimmed)
+	if hard {
+		p.emit("<br>\n")
+	} else {
+		p.text.WriteByte('\n')
+	}
+	p.pos++
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// scanBrackets pairs up square brackets in one pass so link parsing does not
+// rescan the text for every opening bracket. Openers whose pair encloses
+// another pair are marked in nested.
+func (p *inlineParser) scanBrackets() {
+	p.brackets = make(map[int]int)
+	p.nested = make(map[int]bool)
+	var stack []int
+	for i := 0; i < len(p.src); i++ {
+		switch p.src[i] {
+		case '\\':
+			i++
+		case '[':
+			stack = append(stack, i)
+		case ']':
+			if len(stack) > 0 {
+				p.brackets[stack[len(stack)-1]] = i
+				stack = stack[:len(stack)-1]
+				if len(stack) > 0 {
+					p.nested[stack[len(stack)-1]] = true
+				}
+			}
+		}
+	}
+}
+
+// scanBackticks indexes backtick runs by length so a code span's closing run
+// can be found with a binary search.
+func (p *inlineParser) scanBackticks() {
+	p.ticks = make(map[int][]int)
+	for i := 0; i < len(p.src); {
+		if p.src[i] != '`' {
+			i++
+			continue
+		}
+		start := i
+		for i < len(p.src) && p.src[i] == '`' {
+			i++
+		}
+		p.ticks[i-start] = append(p.ticks[i-start], start)
+	}
+}
+
+func (p *inlineParser) parseCodeSpan() {
+	start := p.pos
+	end := start
+	for end < len(p.src) && p.src[end] == '`' {
+		end++
+	}
+	n := end - start
+
+	runs := p.ticks[n]
+	k := sort.SearchInts(runs, end)
+	if k >= len(runs) {
+		p.text.WriteString(p.src[start:end])
+		p.pos = end
+		return
+	}
+	closeAt := runs[k]
+
+	code := strings.ReplaceAll(p.src[end:closeAt], "\n", " ")
+	if len(code) >= 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.Trim(code, " ") != "" {
+		code = code[1 : len(code)-1]
+	}
+	p.emit("<code>" + html.EscapeString(code) + "</code>")
+	p.pos = closeAt + n
+}
+
+func (p *inlineParser) parseDelimiterRun() {
+	c := p.src[p.pos]
+	start := p.pos
+	for p.pos < len(p.src) && p.src[p.pos] == c {
+		p.pos++
+	}
+	count := p.pos - start
+
+	before := ' '
+	if start > 0 {
+		before, _ = utf8.DecodeLastRuneInString(p.src[:start])
+	}
+	after := ' '
+	if p.pos < len(p.src) {
+		after, _ = utf8.DecodeRuneInString(p.src[p.pos:])
+	}
+
+	leftFlanking := !unicode.IsSpace(after) &&
+		(!isPunct(after) || unicode.IsSpace(before) || isPunct(before))
+	rightFlanking := !unicode.IsSpace(before) &&
+		(!isPunct(before) || unicode.IsSpace(after) || isPunct(after))
+
+	node := &inlineNode{delim: c, count: count, origCount: count}
+	if c == '_' {
+		node.canOpen = leftFlanking && (!rightFlanking || isPunct(before))
+		node.canClose = rightFlanking && (!leftFlanking || isPunct(after))
+	} else {
+		node.canOpen = leftFlanking
+		node.canClose = rightFlanking
+	}
+	if c == '~' && count > 2 {
+		node.canOpen, node.canClose = false, false
+	}
+
+	p.flushText()
+	p.nodes = append(p.nodes, node)
+}
+
+// processEmphasis matches delimiter runs into <em>, <strong> and <del> tags
+// following the CommonMark delimiter algorithm. Runs leave the stack once
+// they are used up or passed over, and bottom records, per kind of closer,
+// how far down the stack is already known to hold no opener for it, so
+// every run is looked at a bounded number of times.
+func (p *inlineParser) processEmphasis() {
+	type closerKind struct {
+		delim   byte
+		canOpen bool
+		mod3    int
+	}
+	var stack []*inlineNode
+	bottom := make(map[closerKind]int)
+	truncate := func(n int) {
+		stack = stack[:n]
+		for k, b := range bottom {
+			if b > n {
+				bottom[k] = n
+			}
+		}
+	}
+
+	for _, closer := range p.nodes {
+		if closer.delim == 0 {
+			continue
+		}
+		if !closer.canClose {
+			if closer.canOpen {
+				stack = append(stack, closer)
+			}
+			continue
+		}
+
+		kind := closerKind{closer.delim, closer.canOpen, closer.origCount % 3}
+		for closer.count > 0 {
+			oi := -1
+			for j := len(stack) - 1; j >= bottom[kind]; j-- {
+				opener := stack[j]
+				if opener.delim != closer.delim {
+					continue
+				}
+				if closer.delim == '~' {
+					if opener.count != closer.count {
+						continue
+					}
+				} else if (opener.canClose || closer.canOpen) &&
+					(opener.origCount+closer.origCount)%3 == 0 &&
+					!(opener.origCount%3 == 0 && closer.origCount%3 == 0) {
+					continue
+				}
+				oi = j
+				break
+			}
+
+			if oi < 0 {
+				bottom[kind] = len(stack)
+				break
+			}
+
+			opener := stack[oi]
+			n := 1
+			var tag string
+			switch {
+			case closer.delim == '~':
+				n = closer.count
+				tag = "del"
+			case opener.count >= 2 && closer.count >= 2:
+				n = 2
+				tag = "strong"
+			default:
+				tag = "em"
+			}
+
+			opener.count -= n
+			closer.count -= n
+			opener.opens = "<" + tag + ">" + opener.opens
+			closer.closes += "</" + tag + ">"
+
+			// Runs between the pair can no longer match anything.
+			if opener.count == 0 {
+				truncate(oi)
+			} else {
+				truncate(oi + 1)
+			}
+		}
+
+		if closer.count > 0 && closer.canOpen {
+			stack = append(stack, closer)
+		}
+	}
+}
+
+func (p *inlineParser) parseLink() bool {
+	closeAt, ok := p.brackets[p.pos]
+	if !ok || closeAt+1 >= len(p.src) || p.src[closeAt+1] != '(' {
+		return false
+	}
+	tail := p.src[:min(len(p.src), closeAt+1+maxLinkTail)]
+	dest, title, end, ok := parseLinkTail(tail, closeAt+1)
+	if !ok {
+		return false
+	}
+
+	label := p.r.inlineWith(p.src[p.pos+1:closeAt], true)
+	href, safe := safeURL(dest)
+	if !safe {
+		p.emit(label)
+	} else {
+		p.r.recordLink(localArticleSlug(href))
+		p.emit(linkHTML(href, title, label))
+	}
+	p.pos = end
+	return true
+}
+
+// parseLinkTail parses "(destination "title")" starting at the opening
+// parenthesis and returns the index just past the closing one.
+func parseLinkTail(src string, i int) (dest, title string, end int, ok bool) {
+	i++
+	i = skipSpaces(src, i)
+
+	if i < len(src) && src[i] == '<' {
+		j := i + 1
+		for j < len(src) && src[j] != '>' && src[j] != '\n' && src[j] != '<' {
+			if src[j] == '\\' {
+				j++
+			}
+			j++
+		}
+		if j >= len(src) || src[j] != '>' {
+			return "", "", 0, false
+		}
+		dest = unescape(src[i+1 : j])
+		i = j + 1
+	} else {
+		j := i
+		depth := 0
+		for j < len(src) {
+			c := src[j]
+			if c == '\\' && j+1 < len(src) {
+				j += 2
+				continue
+			}
+			if c == ' ' || c == '\n' || c < 0x20 {
+				break
+			}
+			if c == '(' {
+				depth++
+			}
+			if c == ')' {
+				if depth == 0 {
+					break
+				}
+				depth--
+			}
+			j++
+		}
+		dest = unescape(src[i:j])
+		i = j
+	}
+
+	i = skipSpaces(src, i)
+	if i < len(src) && (src[i] == '"' || src[i] == '\'' || src[i] == '(') {
+		closing := src[i]
+		if closing == '(' {
+			closing = ')'
+		}
+		j := i + 1
+		for j < len(src) && src[j] != closing {
+			if src[j] == '\\' {
+				j++
+			}
+			j++
+		}
+		if j >= len(src) {
+			return "", "", 0, false
+		}
+		title = unescape(src[i+1 : j])
+		i = skipSpaces(src, j+1)
+	}
+
+	if i >= len(src) || src[i] != ')' {
+		return "", "", 0, false
+	}
+	return dest, title, i + 1, true
+}
+
+func (p *inlineParser) parseAutolink() bool {
+	m := autolinkRegex.FindStringSubmatch(p.src[p.pos:])
+	if m == nil {
+		return false
+	}
+	target := m[1]
+	href := target
+	if !strings.Contains(target, ":") {
+		href = "mailto:" + target
+	}
+	if href, ok := safeURL(href); ok {
+		p.emit(linkHTML(href, "", html.EscapeString(target)))
+	} else {
+		p.text.WriteString(m[0])
+	}
+	p.pos += len(m[0])
+	return true
+}
+
+// atWordStart reports whether a bare URL may begin at the current position.
+func (p *inlineParser) atWordStart() bool {
+	if p.pos == 0 {
+		return true
+	}
+	prev := p.src[p.pos-1]
+	return prev == ' ' || prev == '\n' || prev == '(' || prev == '*' || prev == '_' || prev == '~'
+}
+
+func (p *inlineParser) parseBareURL() bool {
+	end := p.pos
+	for end < len(p.src) && p.src[end] != ' ' && p.src[end] != '\n' && p.src[end] != '<' {
+		end++
+	}
+	url := p.src[p.pos:end]
+
+	// Trailing punctuation belongs to the sentence, not the URL, and a
+	// closing parenthesis only stays if it is balanced.
+	for len(url) > 0 {
+		last := url[len(url)-1]
+		if strings.IndexByte("?!.,:*_~'\"", last) >= 0 {
+			url = url[:len(url)-1]
+			continue
+		}
+		if last == ')' && strings.Count(url, "(") < strings.Count(url, ")") {
+			url = url[:len(url)-1]
+			continue
+		}
+		break
+	}
+	if len(url) <= len("https://") {
+		return false
+	}
+
+	href, ok := safeURL(url)
+	if !ok {
+		return false
+	}
+	p.emit(linkHTML(href, "", html.EscapeString(url)))
+	p.pos += len(url)
+	return true
+}
+
+func skipSpaces(src string, i int) int {
+	for i < len(src) && (src[i] == ' ' || src[i] == '\n') {
+		i++
+	}
+	return i
+}
+
+// unescape removes backslashes before ASCII punctuation.
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && isASCIIPunct(s[i+1]) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func isASCIIPunct(c byte) bool {
+	return strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", c) >= 0
+}
+
+func isPunct(r rune) bool {
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
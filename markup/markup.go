@@ -0,0 +1,114 @@
+// Package markup renders article source text to HTML.
+//
+// It implements the Markdown used on the wiki: ATX and setext headings,
+// paragraphs, bullet and ordered lists, blockquotes, fenced code blocks,
+// GitHub-style tables, horizontal rules, emphasis, strikethrough, code
+// spans, links and autolinks, plus the wiki's own syntax: media embeds
+// ![alt](file =WxH center) and [[Page Title]] / [[slug|label]] wiki links.
+// Headings get unique anchor IDs so sections can be linked to, and
+// ParseOutline returns the same headings as a tree for tables of contents.
+// Raw HTML is never passed through: all text is escaped and link
+// destinations are restricted to safe schemes, so the output can be inserted
+// into a page as-is.
+package markup
+
+import "strings"
+
+// maxDepth bounds nesting of blockquotes and lists so hostile input cannot
+// exhaust the stack.
+const maxDepth = 32
+
+// Options customise rendering.
+type Options struct {
+	// LinkExists reports which of the given wiki link slugs name an existing
+	// article. It is called at most once per render with every slug in the
+	// document. Slugs missing from the result render as red links. A nil
+	// LinkExists treats every link as existing.
+	LinkExists func(slugs []string) map[string]bool
+
+	// SectionEditURL, if set, returns the address for editing section n.
+	// Top-level headings then get an edit link pointing to it.
+	SectionEditURL func(n int) string
+
+	// Images describes the uploaded images the document embeds, keyed by
+	// file name. It is called at most once per render with every embedded
+	// file name. Images in the result render with their intrinsic size and
+	// a srcset of their resized variants; others render as a plain <img>.
+	Images func(filenames []string) map[string]Image
+}
+
+// Image is an uploaded image's pixel size and the widths of its resized
+// variants, which are served at /media/thumb/{width}/{filename}.
+type Image struct {
+	Width    int
+	Height   int
+	Variants []int
+}
+
+// Render converts source to sanitized HTML.
+func Render(source string) string {
+	return RenderWith(source, Options{})
+}
+
+// RenderWith converts source to sanitized HTML using opts.
+func RenderWith(source string, opts Options) string {
+	blocks := parseBlocks(splitLines(source), 0)
+	assignHeadingIDs(blocks)
+	numberSections(blocks)
+	r := &renderer{opts: opts}
+	r.resolveWikiLinks(blocks)
+	r.resolveImages(blocks)
+	r.renderBlocks(blocks, false)
+	return r.out.String()
+}
+
+// Links returns the slugs of the articles source links to, through wiki
+// links or Markdown links to /wiki/ paths, in order of first appearance.
+func Links(source string) []string {
+	r := &renderer{links: &linkSet{seen: make(map[string]bool)}}
+	r.renderBlocks(parseBlocks(splitLines(source), 0), false)
+	return r.links.slugs
+}
+
+// Media returns the file names of the uploaded media source embeds, in
+// order of first appearance.
+func Media(source string) []string {
+	r := &renderer{media: &linkSet{seen: make(map[string]bool)}}
+	r.renderBlocks(parseBlocks(splitLines(source), 0), false)
+	return r.media.slugs
+}
+
+type renderer struct {
+	out    strings.Builder
+	opts   Options
+	exists map[string]bool
+	images map[string]Image
+	links  *linkSet
+	media  *linkSet
+}
+
+// child returns an empty renderer for rendering nested content separately.
+func (r *renderer) child() *renderer {
+	return &renderer{opts: r.opts, exists: r.exists, images: r.images, links: r.links, media: r.media}
+}
+
+type linkSet struct {
+	seen  map[string]bool
+	slugs []string
+}
+
+func (r *renderer) recordLink(slug string) {
+	if r.links == nil || slug == "" || r.links.seen[slug] {
+		return
+	}
+	r.links.seen[slug] = true
+	r.links.slugs = append(r.links.slugs, slug)
+}
+
+func (r *renderer) recordMedia(filename string) {
+	if r.media == nil || r.media.seen[filename] {
+		return
+	}
+	r.media.seen[filename] = true
+	r.media.slugs = append(r.media.slugs, filename)
+}
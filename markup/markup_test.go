@@ -0,0 +1,106 @@
+package markup
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"heading", "# Hi", "<h1 id=\"hi\">Hi</h1>\n"},
+		{"emphasis", "*em* **strong** ~~del~~", "<p><em>em</em> <strong>strong</strong> <del>del</del></p>\n"},
+		{"nested emphasis", "***both*** *a **b** c*", "<p><em><strong>both</strong></em> <em>a <strong>b</strong> c</em></p>\n"},
+		{"emphasis rule of three", "*a**b*", "<p><em>a**b</em></p>\n"},
+		{"emphasis passes failed closer", "*a_**_b*", "<p><em>a_**_b</em></p>\n"},
+		{"unmatched emphasis", "*a _b", "<p>*a _b</p>\n"},
+		{"intraword underscore", "snake_case_name", "<p>snake_case_name</p>\n"},
+		{"code span", "`a*b`", "<p><code>a*b</code></p>\n"},
+		{"escape", `a\*b`, "<p>a*b</p>\n"},
+		{"raw html", "<script>", "<p>&lt;script&gt;</p>\n"},
+		{"link", "[x](/wiki/foo)", "<p><a href=\"/wiki/foo\">x</a></p>\n"},
+		{"unsafe link", "[x](javascript:alert(1))", "<p>x</p>\n"},
+		{"autolink", "<https://a.b>", "<p><a href=\"https://a.b\" rel=\"nofollow noopener noreferrer\">https://a.b</a></p>\n"},
+		{"media block", "![cat](cat.png)", "<div class=\"media-embed media-image\"><img src=\"/media/cat.png\" alt=\"cat\" loading=\"lazy\"></div>\n"},
+		{"media size", "![cat](cat.png =100x center)", "<div class=\"media-embed media-center media-image\"><img src=\"/media/cat.png\" alt=\"cat\" style=\"width:100px\" loading=\"lazy\"></div>\n"},
+		{"media inline", "![a](c.png) text", "<p><span class=\"media-embed media-image\"><img src=\"/media/c.png\" alt=\"a\" loading=\"lazy\"></span> text</p>\n"},
+		{"media invalid name", "![cat](Bad.png)", "<p>![cat](Bad.png)</p>\n"},
+		{"media nested brackets", "![a[b]](c.png)", "<p>!<a href=\"c.png\">a[b]</a></p>\n"},
+		{"media width and height", "x ![a](c.png =100x200)", "<p>x <span class=\"media-embed media-image\"><img src=\"/media/c.png\" alt=\"a\" style=\"width:100px;height:200px\" loading=\"lazy\"></span></p>\n"},
+		{"unclosed media", "![a](c.png", "<p>![a](c.png</p>\n"},
+		{"unclosed bracket", "[a", "<p>[a</p>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Render(tt.src); got != tt.want {
+				t.Errorf("Render(%q) = %q, want %q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMedia(t *testing.T) {
+	got := Media("![a](a.png) and ![b](b.mp4)\n\n![a](a.png) `![c](c.png)`")
+	want := []string{"a.png", "b.mp4"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Media = %q, want %q", got, want)
+	}
+}
+
+// pathological holds inputs that made earlier versions of the inline parser
+// rescan the rest of the text from every opening bracket or delimiter run.
+var pathological = map[string]string{
+	"media openers":      strings.Repeat("![", 20000),
+	"nested media":       strings.Repeat("![", 10000) + strings.Repeat("]", 10000),
+	"unterminated media": strings.Repeat("![a](b", 10000),
+	"unterminated links": strings.Repeat("[a](b (", 10000),
+	"link openers":       strings.Repeat("[a](", 20000),
+	"bracket openers":    strings.Repeat("[", 40000),
+	"wiki link openers":  strings.Repeat("[[a", 20000),
+	"nested wiki links":  strings.Repeat("[[", 10000) + strings.Repeat("]]", 10000),
+	"emphasis openers":   strings.Repeat("*a ", 20000) + strings.Repeat(" a*", 20000),
+	"unmatched emphasis": strings.Repeat("_a", 40000),
+	"mixed emphasis":     strings.Repeat("*_a ", 15000) + strings.Repeat(" a_*", 15000),
+	"failed closers":     strings.Repeat("*a**", 20000),
+}
+
+func TestRenderPathological(t *testing.T) {
+	for name, src := range pathological {
+		t.Run(name, func(t *testing.T) {
+			if out := Render(src); out == "" {
+				t.Errorf("Render returned nothing")
+			}
+		})
+	}
+}
+
+func BenchmarkRenderPathological(b *testing.B) {
+	for name, src := range pathological {
+		b.Run(name, func(b *testing.B) {
+			for b.Loop() {
+				Render(src)
+			}
+		})
+	}
+}
+
+func FuzzRender(f *testing.F) {
+	for _, s := range []string{
+		"# Title\n\nSome *text* with [a link](/wiki/x) and [[Page|label]].",
+		"![alt](file.png =100x200 center)",
+		"| a | b |\n|---|---|\n| 1 | 2 |",
+		"> quote\n\n- item\n  - nested",
+		"```go\ncode\n```",
+	} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		out := Render(src)
+		if strings.Contains(out, "<script") {
+			t.Errorf("Render(%q) passed through a script tag", src)
+		}
+	})
+}
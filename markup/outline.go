@@ -0,0 +1,99 @@
+package markup
+
+import (
+	"html"
+	"strconv"
+	"strings"
+)
+
+// Heading is an entry in an article's outline.
+type Heading struct {
+	Level    int
+	Text     string
+	ID       string
+	Children []Heading
+}
+
+// Outline is the nested heading structure of a document.
+type Outline []Heading
+
+// ParseOutline returns the headings of source nested by level. Headings
+// inside blockquotes and lists are not part of the outline.
+func ParseOutline(source string) Outline {
+	blocks := parseBlocks(splitLines(source), 0)
+	assignHeadingIDs(blocks)
+
+	var flat []Heading
+	for _, b := range blocks {
+		if b.kind == headingBlock {
+			flat = append(flat, Heading{Level: b.level, Text: headingText(b.text), ID: b.id})
+		}
+	}
+	return nestHeadings(flat)
+}
+
+// assignHeadingIDs gives every heading an anchor derived from its text. IDs
+// are unique within the document: repeats get -1, -2, ... appended. next
+// remembers the last suffix tried for each base so repeats stay linear.
+func assignHeadingIDs(blocks []*block) {
+	used := make(map[string]bool)
+	next := make(map[string]int)
+	var walk func([]*block)
+	walk = func(blocks []*block) {
+		for _, b := range blocks {
+			if b.kind == headingBlock {
+				base := Slugify(headingText(b.text))
+				if base == "" {
+					base = "section"
+				}
+				id := base
+				for used[id] {
+					next[base]++
+					id = base + "-" + strconv.Itoa(next[base])
+				}
+				used[id] = true
+				b.id = id
+			}
+			walk(b.children)
+		}
+	}
+	walk(blocks)
+}
+
+// headingText returns the plain text of a heading's inline source.
+func headingText(src string) string {
+	r := &renderer{}
+	rendered := r.inline(src)
+
+	var b strings.Builder
+	inTag := false
+	for i := 0; i < len(rendered); i++ {
+		switch c := rendered[i]; {
+		case c == '<':
+			inTag = true
+		case c == '>':
+			inTag = false
+		case !inTag:
+			b.WriteByte(c)
+		}
+	}
+	return strings.TrimSpace(html.UnescapeString(b.String()))
+}
+
+// nestHeadings turns a flat list of headings into a tree, placing each
+// heading under the nearest preceding heading of a lower level.
+func nestHeadings(flat []Heading) Outline {
+	i := 0
+	return nestFrom(flat, &i, 0)
+}
+
+func nestFrom(flat []Heading, i *int, parentLevel int) Outline {
+	var out Outline
+	for *i < len(flat) && flat[*i].Level > parentLevel {
+		h := flat[*i]
+		*i++
+		h.Children = nestFrom(flat, i, h.Level)
+		out = append(out, h)
+	}
+	return out
+}
@@ -0,0 +1,36 @@
+package markup
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseOutline(t *testing.T) {
+	src := "# Intro\n\n## *Setup*\n\n## Setup\n\n# Setup-1\n\n### Deep\n\n> # Quoted\n\n# !!!"
+	want := Outline{
+		{Level: 1, Text: "Intro", ID: "intro", Children: []Heading{
+			{Level: 2, Text: "Setup", ID: "setup"},
+			{Level: 2, Text: "Setup", ID: "setup-1"},
+		}},
+		{Level: 1, Text: "Setup-1", ID: "setup-1-1", Children: []Heading{
+			{Level: 3, Text: "Deep", ID: "deep"},
+		}},
+		{Level: 1, Text: "!!!", ID: "section"},
+	}
+	if got := ParseOutline(src); !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseOutline = %+v, want %+v", got, want)
+	}
+}
+
+func TestHeadingIDsRepeated(t *testing.T) {
+	const n = 20000
+	src := strings.Repeat("# Same\n\n", n)
+	out := ParseOutline(src)
+	if len(out) != n {
+		t.Fatalf("got %d headings, want %d", len(out), n)
+	}
+	if last := out[n-1].ID; last != "same-19999" {
+		t.Errorf("last ID = %q, want %q", last, "same-19999")
+	}
+}
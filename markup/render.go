@@ -0,0 +1,444 @@
+package markup
+
+import (
+	"fmt"
+	"html"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Matches ![alt](filename =WIDTHxHEIGHT center) — size and center are both optional
+var mediaEmbedRegex = regexp.MustCompile(`^!\[([^\]]*)\]\(([^\s)]+)(?:\s*=(\d*)x(\d*))?(\s+center)?\)`)
+
+// mediaEmbedAnyRegex finds embeds anywhere in a span of text.
+var mediaEmbedAnyRegex = regexp.MustCompile(strings.TrimPrefix(mediaEmbedRegex.String(), "^"))
+
+// maxMediaTail bounds how far past the closing bracket an embed is looked
+// for, as maxLinkTail does for links.
+const maxMediaTail = 512
+
+// contentWidth is the widest an image is shown in an article, in CSS
+// pixels, for the sizes attribute of images without an explicit width.
+const contentWidth = 760
+
+func (r *renderer) renderBlocks(blocks []*block, tight bool) {
+	for _, b := range blocks {
+		switch b.kind {
+		case paragraphBlock:
+			r.renderParagraph(b, tight)
+		case headingBlock:
+			r.renderHeading(b)
+		case codeBlock:
+			r.renderCode(b)
+		case quoteBlock:
+			r.out.WriteString("<blockquote>\n")
+			r.renderBlocks(b.children, false)
+			r.out.WriteString("</blockquote>\n")
+		case listBlock:
+			r.renderList(b)
+		case tableBlock:
+			r.renderTable(b)
+		case ruleBlock:
+			r.out.WriteString("<hr>\n")
+		}
+	}
+}
+
+func (r *renderer) renderHeading(b *block) {
+	fmt.Fprintf(&r.out, `<h%d id="%s">%s`, b.level, b.id, r.inline(b.text))
+	if r.opts.SectionEditURL != nil && b.section > 0 {
+		fmt.Fprintf(&r.out, ` <span class="section-edit"><a href="%s">edit</a></span>`,
+			html.EscapeString(r.opts.SectionEditURL(b.section)))
+	}
+	fmt.Fprintf(&r.out, "</h%d>\n", b.level)
+}
+
+func (r *renderer) renderParagraph(b *block, tight bool) {
+	// A paragraph made up only of media embeds renders them as blocks so
+	// they can be sized and centred independently of the text flow.
+	if embeds, ok := r.mediaOnly(b.text); ok {
+		for _, m := range embeds {
+			r.out.WriteString(r.mediaHTML(m, "div"))
+			r.out.WriteString("\n")
+			r.recordMedia(m.filename)
+		}
+		return
+	}
+	if tight {
+		r.out.WriteString(r.inline(b.text))
+		r.out.WriteString("\n")
+		return
+	}
+	r.out.WriteString("<p>")
+	r.out.WriteString(r.inline(b.text))
+	r.out.WriteString("</p>\n")
+}
+
+func (r *renderer) renderCode(b *block) {
+	lang := ""
+	if fields := strings.Fields(b.info); len(fields) > 0 {
+		lang = sanitizeClass(fields[0])
+	}
+	if lang != "" {
+		fmt.Fprintf(&r.out, `<pre><code class="language-%s">`, lang)
+	} else {
+		r.out.WriteString("<pre><code>")
+	}
+	for _, line := range b.code {
+		r.out.WriteString(html.EscapeString(line))
+		r.out.WriteString("\n")
+	}
+	r.out.WriteString("</code></pre>\n")
+}
+
+func (r *renderer) renderList(b *block) {
+	tag := "ul"
+	if b.ordered {
+		tag = "ol"
+	}
+	if b.ordered && b.start != 1 {
+		fmt.Fprintf(&r.out, "<ol start=\"%d\">\n", b.start)
+	} else {
+		fmt.Fprintf(&r.out, "<%s>\n", tag)
+	}
+	for _, item := range b.children {
+		sub := r.child()
+		sub.renderBlocks(item.children, !b.loose)
+		body := sub.out.String()
+
+		r.out.WriteString("<li>")
+		if b.loose || (len(item.children) > 0 && item.children[0].kind != paragraphBlock) {
+			r.out.WriteString("\n")
+		}
+		// Tight items whose last child is text stay on one line: <li>text</li>.
+		if !b.loose && len(item.children) > 0 && item.children[len(item.children)-1].kind == paragraphBlock {
+			body = strings.TrimSuffix(body, "\n")
+		}
+		r.out.WriteString(body)
+		r.out.WriteString("</li>\n")
+	}
+	fmt.Fprintf(&r.out, "</%s>\n", tag)
+}
+
+func (r *renderer) renderTable(b *block) {
+	r.out.WriteString("<table>\n<thead>\n<tr>\n")
+	for i, cell := range b.head {
+		r.out.WriteString(cellTag("th", b.align[i]))
+		r.out.WriteString(r.inline(cell))
+		r.out.WriteString("</th>\n")
+	}
+	r.out.WriteString("</tr>\n</thead>\n")
+	if len(b.rows) > 0 {
+		r.out.WriteString("<tbody>\n")
+		for _, row := range b.rows {
+			r.out.WriteString("<tr>\n")
+			for i, cell := range row {
+				r.out.WriteString(cellTag("td", b.align[i]))
+				r.out.WriteString(r.inline(cell))
+				r.out.WriteString("</td>\n")
+			}
+			r.out.WriteString("</tr>\n")
+		}
+		r.out.WriteString("</tbody>\n")
+	}
+	r.out.WriteString("</table>\n")
+}
+
+func cellTag(tag, align string) string {
+	if align == "" {
+		return "<" + tag + ">"
+	}
+	return fmt.Sprintf(`<%s style="text-align:%s">`, tag, align)
+}
+
+// parseMedia handles the media embed syntax. Embeds naming anything other
+// than a valid uploaded file are left as literal text.
+func (p *inlineParser) parseMedia() bool {
+	open := p.pos + 1
+	closeAt, ok := p.brackets[open]
+	if !ok || p.nested[open] {
+		return false
+	}
+	tail := p.src[:min(len(p.src), closeAt+1+maxMediaTail)]
+	embed, end, ok := parseMediaTail(tail, closeAt+1)
+	if !ok {
+		return false
+	}
+	embed.alt = p.src[open+1 : closeAt]
+	if h := p.r.mediaHTML(embed, "span"); h != "" {
+		p.emit(h)
+		p.r.recordMedia(embed.filename)
+	} else {
+		p.text.WriteString(p.src[p.pos:end])
+	}
+	p.pos = end
+	return true
+}
+
+// parseMediaTail parses "(filename =WxH center)" starting at the opening
+// parenthesis, matching the same text as the tail of mediaEmbedRegex, and
+// returns the index just past the closing one.
+func parseMediaTail(src string, i int) (m mediaEmbed, end int, ok bool) {
+	if i >= len(src) || src[i] != '(' {
+		return m, 0, false
+	}
+	j := i + 1
+	for j < len(src) && !isSpace(src[j]) && src[j] != ')' {
+		j++
+	}
+	if j == i+1 {
+		return m, 0, false
+	}
+	m.filename = src[i+1 : j]
+
+	k := skipWhitespace(src, j)
+	if k < len(src) && src[k] == '=' {
+		w := skipDigits(src, k+1)
+		if w < len(src) && src[w] == 'x' {
+			h := skipDigits(src, w+1)
+			m.width, m.height = src[k+1:w], src[w+1:h]
+			j = h
+		}
+	}
+	if k := skipWhitespace(src, j); k > j && strings.HasPrefix(src[k:], "center") {
+		m.centered = true
+		j = k + len("center")
+	}
+
+	if j >= len(src) || src[j] != ')' {
+		return m, 0, false
+	}
+	return m, j + 1, true
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r'
+}
+
+func skipWhitespace(src string, i int) int {
+	for i < len(src) && isSpace(src[i]) {
+		i++
+	}
+	return i
+}
+
+func skipDigits(src string, i int) int {
+	for i < len(src) && src[i] >= '0' && src[i] <= '9' {
+		i++
+	}
+	return i
+}
+
+type mediaEmbed struct {
+	alt      string
+	filename string
+	width    string
+	height   string
+	centered bool
+}
+
+func newMediaEmbed(m []string) mediaEmbed {
+	return mediaEmbed{
+		alt:      m[1],
+		filename: m[2],
+		width:    m[3],
+		height:   m[4],
+		centered: strings.TrimSpace(m[5]) == "center",
+	}
+}
+
+// resolveImages looks up every embedded file in the document with a single
+// Images call.
+func (r *renderer) resolveImages(blocks []*block) {
+	if r.opts.Images == nil {
+		return
+	}
+	seen := make(map[string]bool)
+	var filenames []string
+	walkInline(blocks, func(text string) {
+		for _, m := range mediaEmbedAnyRegex.FindAllStringSubmatch(text, -1) {
+			if name := m[2]; isValidMediaFilename(name) && !seen[name] {
+				seen[name] = true
+				filenames = append(filenames, name)
+			}
+		}
+	})
+	if len(filenames) == 0 {
+		return
+	}
+	r.images = r.opts.Images(filenames)
+}
+
+// mediaOnly reports whether text consists solely of media embeds.
+func (r *renderer) mediaOnly(text string) ([]mediaEmbed, bool) {
+	var embeds []mediaEmbed
+	rest := strings.TrimSpace(text)
+	for rest != "" {
+		m := mediaEmbedRegex.FindStringSubmatch(rest)
+		if m == nil {
+			return nil, false
+		}
+		embed := newMediaEmbed(m)
+		if r.mediaHTML(embed, "div") == "" {
+			return nil, false
+		}
+		embeds = append(embeds, embed)
+		rest = strings.TrimSpace(rest[len(m[0]):])
+	}
+	return embeds, len(embeds) > 0
+}
+
+// mediaHTML renders an embed wrapped in tag, or returns "" if the file name
+// is invalid or not a supported media type.
+func (r *renderer) mediaHTML(m mediaEmbed, tag string) string {
+	if !isValidMediaFilename(m.filename) {
+		return ""
+	}
+
+	mediaURL := "/media/" + m.filename
+	ext := strings.ToLower(filepath.Ext(m.filename))
+	alt := html.EscapeString(m.alt)
+
+	style := buildSizeStyle(m.width, m.height)
+	class := "media-embed"
+	if m.centered {
+		class += " media-center"
+	}
+
+	switch ext {
+	case ".mp4", ".webm":
+		return fmt.Sprintf(
+			`<%s class="%s media-video"><video controls preload="metadata" title="%s"%s><source src="%s">Your browser does not support video playback.</video></%s>`,
+			tag, class, alt, style, mediaURL, tag,
+		)
+	case ".jpg", ".jpeg", ".png", ".gif", ".webp":
+		return fmt.Sprintf(
+			`<%s class="%s media-image"><img src="%s" alt="%s"%s%s loading="lazy"></%s>`,
+			tag, class, mediaURL, alt, r.imageAttrs(m), style, tag,
+		)
+	default:
+		return ""
+	}
+}
+
+// imageAttrs returns the srcset, sizes, width and height attributes for an
+// embedded image, or "" if the image is unknown.
+func (r *renderer) imageAttrs(m mediaEmbed) string {
+	img, ok := r.images[m.filename]
+	if !ok || img.Width <= 0 || img.Height <= 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	if len(img.Variants) > 0 {
+		var srcset []string
+		for _, w := range img.Variants {
+			srcset = append(srcset, fmt.Sprintf("/media/thumb/%d/%s %dw", w, m.filename, w))
+		}
+		srcset = append(srcset, fmt.Sprintf("/media/%s %dw", m.filename, img.Width))
+
+		// The displayed width: as written, derived from the written
+		// height, or the column width.
+		shown := contentWidth
+		if w, err := strconv.Atoi(m.width); err == nil && w > 0 {
+			shown = w
+		} else if h, err := strconv.Atoi(m.height); err == nil && h > 0 {
+			shown = h * img.Width / img.Height
+		}
+		shown = min(shown, contentWidth, img.Width)
+		fmt.Fprintf(&b, ` srcset="%s" sizes="(max-width: %dpx) 100vw, %dpx"`,
+			strings.Join(srcset, ", "), shown, shown)
+	}
+	fmt.Fprintf(&b, ` width="%d" height="%d"`, img.Width, img.Height)
+	return b.String()
+}
+
+func buildSizeStyle(widthStr, heightStr string) string {
+	if widthStr == "" && heightStr == "" {
+		return ""
+	}
+	var parts []string
+	if widthStr != "" {
+		parts = append(parts, "width:"+widthStr+"px")
+	}
+	if heightStr != "" {
+		parts = append(parts, "height:"+heightStr+"px")
+		// Images carry width and height attributes; without this the
+		// width attribute would apply and stretch the image.
+		if widthStr == "" {
+			parts = append(parts, "width:auto")
+		}
+	}
+	return fmt.Sprintf(` style="%s"`, strings.Join(parts, ";"))
+}
+
+func isValidMediaFilename(filename string) bool {
+	for _, c := range filename {
+		if !((c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '.') {
+			return false
+		}
+	}
+	return len(filename) > 0 && !strings.Contains(filename, "..")
+}
+
+// safeURL checks a link destination. Relative URLs and http, https and
+// mailto links are allowed; anything else, such as javascript:, is not.
+func safeURL(dest string) (string, bool) {
+	dest = strings.TrimSpace(dest)
+	if dest == "" {
+		return "", false
+	}
+	for _, c := range dest {
+		if c < 0x20 || c == 0x7f {
+			return "", false
+		}
+	}
+
+	if i := strings.IndexAny(dest, ":/?#"); i >= 0 && dest[i] == ':' {
+		switch strings.ToLower(dest[:i]) {
+		case "http", "https", "mailto":
+		default:
+			return "", false
+		}
+	}
+	return strings.ReplaceAll(dest, " ", "%20"), true
+}
+
+func isExternal(href string) bool {
+	lower := strings.ToLower(href)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") ||
+		strings.HasPrefix(href, "//")
+}
+
+func linkHTML(href, title, label string) string {
+	var b strings.Builder
+	b.WriteString(`<a href="`)
+	b.WriteString(html.EscapeString(href))
+	b.WriteString(`"`)
+	if title != "" {
+		b.WriteString(` title="`)
+		b.WriteString(html.EscapeString(title))
+		b.WriteString(`"`)
+	}
+	if isExternal(href) {
+		b.WriteString(` rel="nofollow noopener noreferrer"`)
+	}
+	b.WriteString(">")
+	b.WriteString(label)
+	b.WriteString("</a>")
+	return b.String()
+}
+
+// sanitizeClass keeps only characters that are safe in a class name.
+func sanitizeClass(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '+' || c == '#' {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
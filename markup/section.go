@@ -0,0 +1,109 @@
+package markup
+
+import "strings"
+
+// Section is a part of a document that can be edited on its own. Section 0
+// is the text before the first heading; section N starts at the Nth
+// top-level heading and runs until the next heading of the same or a higher
+// level, so it includes its subsections.
+type Section struct {
+	Index int
+	Level int
+	Title string
+	start int
+	end   int
+}
+
+// Sections lists the sections of source, starting with section 0.
+func Sections(source string) []Section {
+	lines := splitLines(source)
+	blocks := parseBlocks(lines, 0)
+
+	var headings []*block
+	for _, b := range blocks {
+		if b.kind == headingBlock {
+			headings = append(headings, b)
+		}
+	}
+
+	lead := Section{end: len(lines)}
+	if len(headings) > 0 {
+		lead.end = headings[0].line
+	}
+	sections := []Section{lead}
+
+	for n, h := range headings {
+		s := Section{Index: n + 1, Level: h.level, Title: headingText(h.text), start: h.line, end: len(lines)}
+		for _, next := range headings[n+1:] {
+			if next.level <= h.level {
+				s.end = next.line
+				break
+			}
+		}
+		sections = append(sections, s)
+	}
+	return sections
+}
+
+// numberSections numbers the top-level headings in the same order as
+// Sections.
+func numberSections(blocks []*block) {
+	n := 0
+	for _, b := range blocks {
+		if b.kind == headingBlock {
+			n++
+			b.section = n
+		}
+	}
+}
+
+// ExtractSection returns the source of section n, or false if there is no
+// such section.
+func ExtractSection(source string, n int) (string, bool) {
+	sections := Sections(source)
+	if n < 0 || n >= len(sections) {
+		return "", false
+	}
+	lines := rawLines(source)
+	s := sections[n]
+	return strings.Join(lines[s.start:trimBlankEnd(lines, s.start, s.end)], "\n"), true
+}
+
+// ReplaceSection returns source with section n replaced by text, or false if
+// there is no such section. Blank lines separating the section from the next
+// one are kept.
+func ReplaceSection(source string, n int, text string) (string, bool) {
+	sections := Sections(source)
+	if n < 0 || n >= len(sections) {
+		return "", false
+	}
+	lines := rawLines(source)
+	s := sections[n]
+	end := trimBlankEnd(lines, s.start, s.end)
+
+	var out []string
+	out = append(out, lines[:s.start]...)
+	if text = strings.TrimRight(text, "\r\n"); text != "" {
+		out = append(out, rawLines(text)...)
+	} else {
+		// A removed section takes its separating blank lines with it.
+		end = s.end
+	}
+	out = append(out, lines[end:]...)
+	return strings.Join(out, "\n"), true
+}
+
+// rawLines splits source into lines like splitLines but leaves tabs alone,
+// so spliced text is written back unchanged.
+func rawLines(source string) []string {
+	source = strings.ReplaceAll(source, "\r\n", "\n")
+	source = strings.ReplaceAll(source, "\r", "\n")
+	return strings.Split(source, "\n")
+}
+
+func trimBlankEnd(lines []string, start, end int) int {
+	for end > start && isBlank(lines[end-1]) {
+		end--
+	}
+	return end
+}
@@ -0,0 +1,149 @@
+package markup
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Matches [[target]] and [[target|label]]
+var wikiLinkRegex = regexp.MustCompile(`\[\[([^\[\]|\n]+)(?:\|([^\[\]\n]*))?\]\]`)
+
+// Slugify converts a title to the lowercase, hyphen-separated form used in
+// article URLs.
+func Slugify(title string) string {
+	var result []byte
+	prevHyphen := false
+	for _, r := range strings.ToLower(strings.TrimSpace(title)) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			result = append(result, byte(r))
+			prevHyphen = false
+		} else if r == ' ' || r == '-' || r == '_' {
+			if !prevHyphen && len(result) > 0 {
+				result = append(result, '-')
+				prevHyphen = true
+			}
+		}
+	}
+	if len(result) > 0 && result[len(result)-1] == '-' {
+		result = result[:len(result)-1]
+	}
+	return string(result)
+}
+
+// splitWikiTarget separates a link target into the page slug and an
+// optional section fragment, as in [[Page Title#Section]].
+func splitWikiTarget(target string) (slug, fragment string) {
+	page, section, _ := strings.Cut(target, "#")
+	return Slugify(page), Slugify(section)
+}
+
+// resolveWikiLinks looks up every wiki link target in the document with a
+// single LinkExists call.
+func (r *renderer) resolveWikiLinks(blocks []*block) {
+	if r.opts.LinkExists == nil {
+		return
+	}
+	seen := make(map[string]bool)
+	var slugs []string
+	walkInline(blocks, func(text string) {
+		for _, m := range wikiLinkRegex.FindAllStringSubmatch(text, -1) {
+			slug, _ := splitWikiTarget(m[1])
+			if slug != "" && !seen[slug] {
+				seen[slug] = true
+				slugs = append(slugs, slug)
+			}
+		}
+	})
+	if len(slugs) == 0 {
+		return
+	}
+	r.exists = r.opts.LinkExists(slugs)
+	if r.exists == nil {
+		r.exists = make(map[string]bool)
+	}
+}
+
+// walkInline calls fn with the inline source of every block.
+func walkInline(blocks []*block, fn func(text string)) {
+	for _, b := range blocks {
+		switch b.kind {
+		case paragraphBlock, headingBlock:
+			fn(b.text)
+		case tableBlock:
+			for _, cell := range b.head {
+				fn(cell)
+			}
+			for _, row := range b.rows {
+				for _, cell := range row {
+					fn(cell)
+				}
+			}
+		}
+		walkInline(b.children, fn)
+	}
+}
+
+// parseWikiLink handles [[target]] and [[target|label]] at p.pos. The inner
+// pair of brackets is looked up in the bracket pre-scan, so an unclosed link
+// costs nothing to reject.
+func (p *inlineParser) parseWikiLink() bool {
+	open := p.pos + 1
+	closeAt, ok := p.brackets[open]
+	if !ok || p.nested[open] || p.brackets[p.pos] != closeAt+1 {
+		return false
+	}
+	inner := p.src[open+1 : closeAt]
+	if strings.ContainsAny(inner, "[]\n") {
+		return false
+	}
+	target, label, hasLabel := strings.Cut(inner, "|")
+	target = strings.TrimSpace(target)
+	if l := strings.TrimSpace(label); !hasLabel || l == "" {
+		label = target
+	} else {
+		label = l
+	}
+
+	slug, fragment := splitWikiTarget(target)
+	if slug == "" {
+		return false
+	}
+	labelHTML := p.r.inlineWith(label, true)
+	p.r.recordLink(slug)
+
+	if p.r.exists != nil && !p.r.exists[slug] {
+		page, _, _ := strings.Cut(target, "#")
+		href := "/wiki/new?title=" + url.QueryEscape(strings.TrimSpace(page))
+		p.emit(`<a href="` + html.EscapeString(href) + `" class="wikilink wikilink-missing" title="` +
+			html.EscapeString(strings.TrimSpace(page)) + ` (page does not exist)">` + labelHTML + `</a>`)
+	} else {
+		href := "/wiki/" + slug
+		if fragment != "" {
+			href += "#" + fragment
+		}
+		p.emit(`<a href="` + href + `" class="wikilink">` + labelHTML + `</a>`)
+	}
+	p.pos = closeAt + 2
+	return true
+}
+
+// localArticleSlug returns the slug of an article linked to by a relative
+// /wiki/ URL, or "" for any other destination.
+func localArticleSlug(href string) string {
+	rest, ok := strings.CutPrefix(href, "/wiki/")
+	if !ok {
+		return ""
+	}
+	if i := strings.IndexAny(rest, "/?#"); i >= 0 {
+		if rest[i] == '/' {
+			return ""
+		}
+		rest = rest[:i]
+	}
+	if rest == "new" || Slugify(rest) != rest {
+		return ""
+	}
+	return rest
+}
@@ -0,0 +1,59 @@
+package markup
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWikiLinks(t *testing.T) {
+	exists := func(slugs []string) map[string]bool {
+		return map[string]bool{"here": true, "page": true, "page-title": true}
+	}
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"title", "[[Page Title]]", `<p><a href="/wiki/page-title" class="wikilink">Page Title</a></p>`},
+		{"label", "[[Page|the label]]", `<p><a href="/wiki/page" class="wikilink">the label</a></p>`},
+		{"empty label", "[[Page| ]]", `<p><a href="/wiki/page" class="wikilink">Page</a></p>`},
+		{"fragment", "[[Page#Some Section]]", `<p><a href="/wiki/page#some-section" class="wikilink">Page#Some Section</a></p>`},
+		{"missing", "[[Missing Page]]", `<p><a href="/wiki/new?title=Missing+Page" class="wikilink wikilink-missing" title="Missing Page (page does not exist)">Missing Page</a></p>`},
+		{"inside text", "see [[here]].", `<p>see <a href="/wiki/here" class="wikilink">here</a>.</p>`},
+		{"unclosed", "[[Page", `<p>[[Page</p>`},
+		{"half closed", "[[Page]", `<p>[[Page]</p>`},
+		{"empty target", "[[|label]]", `<p>[[|label]]</p>`},
+		{"newline", "[[Page\nTitle]]", "<p>[[Page\nTitle]]</p>"},
+		{"extra bracket", "[[[Page]]]", `<p>[<a href="/wiki/page" class="wikilink">Page</a>]</p>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := strings.TrimSuffix(RenderWith(tt.src, Options{LinkExists: exists}), "\n")
+			if got != tt.want {
+				t.Errorf("RenderWith(%q) = %q, want %q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLinks(t *testing.T) {
+	got := Links("[[Page Title]] [b](/wiki/other) [[page title|again]] [c](https://example.com) [[Third#Part]]")
+	want := []string{"page-title", "other", "third"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Links = %q, want %q", got, want)
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	tests := map[string]string{
+		"Page Title":         "page-title",
+		"  Go_Lang -- Tips ": "go-lang-tips",
+		"C++ & Rust!":        "c-rust",
+		"":                   "",
+	}
+	for in, want := range tests {
+		if got := Slugify(in); got != want {
+			t.Errorf("Slugify(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
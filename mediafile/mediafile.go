@@ -0,0 +1,190 @@
+// Package mediafile checks and cleans uploaded files before they are
+// stored. Sniff identifies a file by its content rather than by what the
+// client claims, and Strip rewrites images without their metadata, so
+// camera details and GPS positions are not published with them.
+package mediafile
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SniffLen is how many leading bytes Sniff looks at.
+const SniffLen = 512
+
+// jpegQuality is used when re-encoding JPEGs.
+const jpegQuality = 90
+
+// ErrInvalid is returned by Strip for files that cannot be decoded as the
+// type they were identified as.
+var ErrInvalid = errors.New("mediafile: file is not a valid image")
+
+// ErrTooLarge is returned by CheckPixels for images with more pixels than
+// allowed.
+var ErrTooLarge = errors.New("mediafile: image has too many pixels")
+
+// Sniff returns the media type of a file from its first SniffLen bytes,
+// without parameters, or "application/octet-stream" if it is unknown.
+func Sniff(head []byte) string {
+	ct := http.DetectContentType(head)
+	if i := strings.IndexByte(ct, ';'); i >= 0 {
+		ct = ct[:i]
+	}
+	return strings.TrimSpace(ct)
+}
+
+// SniffReader sniffs r and then rewinds it.
+func SniffReader(r io.ReadSeeker) (string, error) {
+	head := make([]byte, SniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return Sniff(head[:n]), nil
+}
+
+// CheckPixels reads the size of the image at path from its header and
+// returns ErrTooLarge if it has more than maxPixels pixels. Call it before
+// Strip, which decodes the whole image. Types that Strip does not decode are
+// not checked.
+func CheckPixels(path, mimeType string, maxPixels int64) error {
+	switch mimeType {
+	case "image/jpeg", "image/png", "image/gif":
+	default:
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return ErrInvalid
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > maxPixels {
+		return ErrTooLarge
+	}
+	return nil
+}
+
+// Strip rewrites the image at path without metadata. JPEG, PNG and GIF
+// images are decoded and encoded again, keeping only the pixels; JPEGs are
+// turned upright first, since their orientation tag goes too. WebP files,
+// which the standard library cannot encode, have their EXIF and XMP chunks
+// removed. Other types are left untouched.
+func Strip(path, mimeType string) error {
+	var clean func(data []byte) ([]byte, error)
+	switch mimeType {
+	case "image/jpeg":
+		clean = cleanJPEG
+	case "image/png":
+		clean = cleanPNG
+	case "image/gif":
+		clean = cleanGIF
+	case "image/webp":
+		clean = cleanWebP
+	default:
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	out, err := clean(data)
+	if err != nil {
+		return err
+	}
+	return replaceFile(path, out)
+}
+
+func cleanJPEG(data []byte) ([]byte, error) {
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	img = orient(img, jpegOrientation(data))
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func cleanPNG(data []byte) ([]byte, error) {
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// cleanGIF keeps every frame and its timing, dropping comment and
+// application extensions other than the loop count.
+func cleanGIF(data []byte) ([]byte, error) {
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SHA256 returns the hex-encoded SHA-256 of the file at path.
+func SHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// replaceFile writes data to a temporary file next to path and renames it
+// over path, so a failure never leaves a half-written file behind.
+func replaceFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".strip-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
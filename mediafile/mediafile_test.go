@@ -0,0 +1,224 @@
+package mediafile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// exifSegment builds an APP1 segment holding a TIFF structure whose first
+// IFD has a single orientation entry.
+func exifSegment(order binary.ByteOrder, orientation uint16) []byte {
+	tiff := make([]byte, 8+2+12+4)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)
+	order.PutUint16(tiff[10:], 0x0112)
+	order.PutUint16(tiff[12:], 3)
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], orientation)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+func jpegWith(segments ...[]byte) []byte {
+	data := []byte{0xFF, 0xD8}
+	for _, s := range segments {
+		data = append(data, s...)
+	}
+	return append(data, 0xFF, 0xDA, 0, 2)
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	comment := []byte{0xFF, 0xFE, 0, 5, 'a', 'b', 'c'}
+	truncated := exifSegment(binary.BigEndian, 6)
+	truncated = truncated[:len(truncated)-8]
+
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"no exif", jpegWith(comment), 1},
+		{"little endian", jpegWith(exifSegment(binary.LittleEndian, 6)), 6},
+		{"big endian", jpegWith(comment, exifSegment(binary.BigEndian, 3)), 3},
+		{"out of range", jpegWith(exifSegment(binary.LittleEndian, 9)), 1},
+		{"truncated", append([]byte{0xFF, 0xD8}, truncated...), 1},
+		{"after scan", append(jpegWith(), exifSegment(binary.LittleEndian, 6)...), 1},
+		{"not a jpeg", []byte("GIF89a"), 1},
+		{"empty", nil, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jpegOrientation(tt.data); got != tt.want {
+				t.Errorf("jpegOrientation = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripRotatesJPEG(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+	data := append([]byte{0xFF, 0xD8}, exifSegment(binary.LittleEndian, 6)...)
+	data = append(data, encoded[2:]...)
+
+	path := filepath.Join(t.TempDir(), "photo.jpg")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Strip(path, "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+
+	stripped, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stripped, []byte("Exif\x00\x00")) {
+		t.Error("stripped JPEG still has EXIF data")
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(stripped))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Width != 2 || cfg.Height != 4 {
+		t.Errorf("stripped image is %dx%d, want 2x4", cfg.Width, cfg.Height)
+	}
+}
+
+func chunk(fourcc string, payload []byte) []byte {
+	c := make([]byte, 8, 8+len(payload)+1)
+	copy(c, fourcc)
+	binary.LittleEndian.PutUint32(c[4:], uint32(len(payload)))
+	c = append(c, payload...)
+	if len(payload)%2 == 1 {
+		c = append(c, 0)
+	}
+	return c
+}
+
+func riff(chunks ...[]byte) []byte {
+	data := []byte("RIFF\x00\x00\x00\x00WEBP")
+	for _, c := range chunks {
+		data = append(data, c...)
+	}
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(data)-8))
+	return data
+}
+
+// trimRIFF drops the last n bytes of a RIFF file and fixes up its size.
+func trimRIFF(data []byte, n int) []byte {
+	data = data[:len(data)-n]
+	binary.LittleEndian.PutUint32(data[4:], uint32(len(data)-8))
+	return data
+}
+
+func TestCleanWebP(t *testing.T) {
+	vp8x := func(flags byte) []byte {
+		return chunk("VP8X", []byte{flags, 0, 0, 0, 1, 0, 0, 1, 0, 0})
+	}
+	bitstream := chunk("VP8L", []byte{0x2F, 1, 2, 3, 4})
+
+	tests := []struct {
+		name string
+		in   []byte
+		want []byte
+	}{
+		{"no metadata", riff(bitstream), riff(bitstream)},
+		{"metadata removed",
+			riff(vp8x(0x10|vp8xEXIF|vp8xXMP), bitstream, chunk("EXIF", []byte("exif data")), chunk("XMP ", []byte("<x/>"))),
+			riff(vp8x(0x10), bitstream)},
+		{"unpadded last chunk",
+			trimRIFF(riff(vp8x(0), chunk("EXIF", []byte("e")), bitstream), 1),
+			riff(vp8x(0), bitstream)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cleanWebP(tt.in)
+			if err != nil {
+				t.Fatalf("cleanWebP: %v", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("cleanWebP =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCleanWebPInvalid(t *testing.T) {
+	overlong := riff(chunk("VP8L", []byte{1, 2, 3, 4}))
+	binary.LittleEndian.PutUint32(overlong[16:], 100)
+
+	tests := map[string][]byte{
+		"not riff":         []byte("RIFX\x04\x00\x00\x00WEBP"),
+		"not webp":         riff()[:8],
+		"size past end":    append([]byte("RIFF\xff\x00\x00\x00WEBP"), chunk("VP8L", nil)...),
+		"chunk past end":   overlong,
+		"short chunk":      trimRIFF(riff(chunk("VP8L", nil)), 4),
+		"empty vp8x chunk": riff(chunk("VP8X", nil)),
+	}
+	for name, in := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := cleanWebP(in); err != ErrInvalid {
+				t.Errorf("cleanWebP error = %v, want ErrInvalid", err)
+			}
+		})
+	}
+}
+
+func TestCheckPixels(t *testing.T) {
+	dir := t.TempDir()
+	img := image.NewGray(image.Rect(0, 0, 100, 50))
+	img.Set(0, 0, color.White)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	pngPath := filepath.Join(dir, "image.png")
+	junkPath := filepath.Join(dir, "junk.png")
+	if err := os.WriteFile(pngPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(junkPath, []byte("not an image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		path      string
+		mimeType  string
+		maxPixels int64
+		want      error
+	}{
+		{"within limit", pngPath, "image/png", 5000, nil},
+		{"over limit", pngPath, "image/png", 4999, ErrTooLarge},
+		{"invalid", junkPath, "image/png", 5000, ErrInvalid},
+		{"not decoded", junkPath, "image/webp", 1, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckPixels(tt.path, tt.mimeType, tt.maxPixels); err != tt.want {
+				t.Errorf("CheckPixels = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
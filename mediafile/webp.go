@@ -0,0 +1,60 @@
+package mediafile
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// VP8X feature flags for the metadata chunks.
+const (
+	vp8xEXIF = 0x08
+	vp8xXMP  = 0x04
+)
+
+// cleanWebP removes the EXIF and XMP chunks from a WebP file and clears
+// the flags announcing them. The image data itself is copied unchanged.
+func cleanWebP(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, ErrInvalid
+	}
+	size := int(binary.LittleEndian.Uint32(data[4:]))
+	if size+8 > len(data) || size < 4 {
+		return nil, ErrInvalid
+	}
+	body := data[12 : 8+size]
+
+	var out bytes.Buffer
+	out.WriteString("RIFF\x00\x00\x00\x00WEBP")
+	for len(body) > 0 {
+		if len(body) < 8 {
+			return nil, ErrInvalid
+		}
+		fourcc := string(body[:4])
+		n := int(binary.LittleEndian.Uint32(body[4:]))
+		padded := n + n&1
+		if 8+n > len(body) {
+			return nil, ErrInvalid
+		}
+		chunk := body[:min(8+padded, len(body))]
+		body = body[len(chunk):]
+
+		switch fourcc {
+		case "EXIF", "XMP ":
+			continue
+		case "VP8X":
+			if n < 1 {
+				return nil, ErrInvalid
+			}
+			chunk = bytes.Clone(chunk)
+			chunk[8] &^= vp8xEXIF | vp8xXMP
+		}
+		out.Write(chunk)
+		if len(chunk) < 8+padded {
+			out.WriteByte(0)
+		}
+	}
+
+	result := out.Bytes()
+	binary.LittleEndian.PutUint32(result[4:], uint32(len(result)-8))
+	return result, nil
+}
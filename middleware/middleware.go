@@ -52,7 +52,7 @@ func LoadSession(next http.Handler) http.Handler {
 		}
 
 		user, err := models.GetUserByID(session.UserID)
-		if err != nil {
+		if err != nil || user.Disabled {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -73,6 +73,18 @@ func RequireAuth(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// RequirePermission lets the request through only for users whose role
+// grants perm. Visitors who are not logged in are sent to the login page.
+func RequirePermission(perm models.Permission, next http.HandlerFunc) http.HandlerFunc {
+	return RequireAuth(func(w http.ResponseWriter, r *http.Request) {
+		if !GetUser(r).Can(perm) {
+			http.Error(w, "Forbidden - you do not have permission to do this", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
 func RequireCSRF(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		sessionToken := GetSessionToken(r)
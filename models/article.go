@@ -1,11 +1,14 @@
 package models
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
-	"strings"
 	"time"
 
+	"github.com/lib/pq"
 	"silic0n-wiki/database"
+	"silic0n-wiki/markup"
 )
 
 type Article struct {
@@ -24,58 +27,74 @@ type ArticleWithCategory struct {
 	CategoryName string
 	CategorySlug string
 	Tags         []TagWithCategory
+	Outline      markup.Outline
+	Protection   Protection
 }
 
 func GetArticleBySlug(slug string) (*ArticleWithCategory, error) {
+	return getLiveArticle(`a.slug = $1`, slug)
+}
+
+// GetLiveArticleByID is GetArticleBySlug by id: articles in the trash are
+// not found.
+func GetLiveArticleByID(id int) (*ArticleWithCategory, error) {
+	return getLiveArticle(`a.id = $1`, id)
+}
+
+func getLiveArticle(where string, arg any) (*ArticleWithCategory, error) {
 	article := &ArticleWithCategory{}
 	err := database.DB.QueryRow(
 		`SELECT a.id, a.slug, a.title, a.content, COALESCE(a.category_id, 0),
 		        a.last_edited_by, a.created_at, a.updated_at,
-		        COALESCE(c.name, '') as category_name, COALESCE(c.slug, '') as category_slug
+		        COALESCE(c.name, '') as category_name, COALESCE(c.slug, '') as category_slug,
+		        a.protection, a.protection_expires_at
 		FROM articles a
 		LEFT JOIN categories c ON a.category_id = c.id
-		WHERE a.slug = $1`,
-		slug,
+		WHERE `+where+` AND a.deleted_at IS NULL`,
+		arg,
 	).Scan(&article.ID, &article.Slug, &article.Title, &article.Content, &article.CategoryID,
 		&article.LastEditedBy, &article.CreatedAt, &article.UpdatedAt,
-		&article.CategoryName, &article.CategorySlug)
+		&article.CategoryName, &article.CategorySlug,
+		&article.Protection.Level, &article.Protection.ExpiresAt)
 
 	if err != nil {
 		return nil, err
 	}
 
+	article.Outline = markup.ParseOutline(article.Content)
 	return article, nil
 }
 
-func SearchArticles(query string) ([]Article, error) {
-	rows, err := database.DB.Query(
-		`SELECT id, slug, title, content, created_at, updated_at
+func GetArticleByID(id int) (*Article, error) {
+	return getArticleByID(database.DB, id, "")
+}
+
+// lockArticle reads an article and locks its row until tx ends, so that
+// nothing else can change it between the read and the write that follows.
+func lockArticle(tx *sql.Tx, id int) (*Article, error) {
+	return getArticleByID(tx, id, " FOR UPDATE")
+}
+
+func getArticleByID(q queryer, id int, lock string) (*Article, error) {
+	article := &Article{}
+	err := q.QueryRow(
+		`SELECT id, slug, title, content, COALESCE(category_id, 0), last_edited_by, created_at, updated_at
 		FROM articles
-		WHERE title ILIKE '%' || $1 || '%' OR slug ILIKE '%' || $1 || '%'
-		ORDER BY title
-		LIMIT 10`,
-		query,
-	)
+		WHERE id = $1`+lock,
+		id,
+	).Scan(&article.ID, &article.Slug, &article.Title, &article.Content, &article.CategoryID,
+		&article.LastEditedBy, &article.CreatedAt, &article.UpdatedAt)
+
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var articles []Article
-	for rows.Next() {
-		var a Article
-		if err := rows.Scan(&a.ID, &a.Slug, &a.Title, &a.Content, &a.CreatedAt, &a.UpdatedAt); err != nil {
-			return nil, err
-		}
-		articles = append(articles, a)
-	}
 
-	return articles, rows.Err()
+	return article, nil
 }
 
 func GetAllArticles() ([]Article, error) {
 	rows, err := database.DB.Query(
-		"SELECT id, slug, title, content, created_at, updated_at FROM articles ORDER BY title",
+		"SELECT id, slug, title, content, created_at, updated_at FROM articles WHERE deleted_at IS NULL ORDER BY title",
 	)
 	if err != nil {
 		return nil, err
@@ -100,8 +119,9 @@ func GetRecentArticles(limit int) ([]ArticleWithCategory, error) {
 		        COALESCE(c.name, '') as category_name, COALESCE(c.slug, '') as category_slug
 		FROM articles a
 		LEFT JOIN categories c ON a.category_id = c.id
-		WHERE a.created_at >= NOW() - INTERVAL '48 hours'
-		   OR a.updated_at >= NOW() - INTERVAL '48 hours'
+		WHERE a.deleted_at IS NULL
+		  AND (a.created_at >= NOW() - INTERVAL '48 hours'
+		       OR a.updated_at >= NOW() - INTERVAL '48 hours')
 		ORDER BY GREATEST(a.created_at, a.updated_at) DESC
 		LIMIT $1`,
 		limit,
@@ -125,29 +145,15 @@ func GetRecentArticles(limit int) ([]ArticleWithCategory, error) {
 }
 
 func Slugify(title string) string {
-	var result []byte
-	prevHyphen := false
-	for _, r := range strings.ToLower(strings.TrimSpace(title)) {
-		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
-			result = append(result, byte(r))
-			prevHyphen = false
-		} else if r == ' ' || r == '-' || r == '_' {
-			if !prevHyphen && len(result) > 0 {
-				result = append(result, '-')
-				prevHyphen = true
-			}
-		}
-	}
-	if len(result) > 0 && result[len(result)-1] == '-' {
-		result = result[:len(result)-1]
-	}
-	return string(result)
+	return markup.Slugify(title)
 }
 
-func SlugExists(slug string, excludeID int) (bool, error) {
+// slugTaken reports whether a live article other than excludeID uses slug.
+// Articles in the trash do not hold on to their slugs.
+func slugTaken(q queryer, slug string, excludeID int) (bool, error) {
 	var count int
-	err := database.DB.QueryRow(
-		`SELECT COUNT(*) FROM articles WHERE slug = $1 AND id != $2`,
+	err := q.QueryRow(
+		`SELECT COUNT(*) FROM articles WHERE slug = $1 AND id != $2 AND deleted_at IS NULL`,
 		slug, excludeID,
 	).Scan(&count)
 	if err != nil {
@@ -156,15 +162,55 @@ func SlugExists(slug string, excludeID int) (bool, error) {
 	return count > 0, nil
 }
 
+// ExistingSlugs reports which of slugs name an article, either directly or
+// through a slug alias left behind by a move.
+func ExistingSlugs(slugs []string) (map[string]bool, error) {
+	existing := make(map[string]bool)
+	if len(slugs) == 0 {
+		return existing, nil
+	}
+
+	rows, err := database.DB.Query(
+		`SELECT slug FROM articles WHERE slug = ANY($1) AND deleted_at IS NULL
+		 UNION
+		 SELECT sa.slug FROM slug_aliases sa
+		 JOIN articles a ON a.id = sa.article_id
+		 WHERE sa.slug = ANY($1) AND a.deleted_at IS NULL`,
+		pq.Array(slugs),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var slug string
+		if err := rows.Scan(&slug); err != nil {
+			return nil, err
+		}
+		existing[slug] = true
+	}
+	return existing, rows.Err()
+}
+
 func GenerateUniqueSlug(title string, excludeID int) (string, error) {
+	return uniqueTitleSlug(database.DB, title, excludeID)
+}
+
+func uniqueTitleSlug(q queryer, title string, excludeID int) (string, error) {
 	base := Slugify(title)
 	if base == "" {
 		base = "article"
 	}
+	return uniqueSlug(q, base, excludeID)
+}
+
+// uniqueSlug returns base, or base with the lowest free numeric suffix.
+func uniqueSlug(q queryer, base string, excludeID int) (string, error) {
 	slug := base
 	counter := 2
 	for {
-		exists, err := SlugExists(slug, excludeID)
+		exists, err := slugTaken(q, slug, excludeID)
 		if err != nil {
 			return "", err
 		}
@@ -176,55 +222,141 @@ func GenerateUniqueSlug(title string, excludeID int) (string, error) {
 	}
 }
 
-func CreateArticle(title, content string, categoryID int, lastEditedBy string) (*Article, error) {
+// queryer is satisfied by both *sql.DB and *sql.Tx so helpers can run
+// inside or outside a transaction.
+type queryer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func CreateArticle(title, content string, categoryID int, tagIDs []int, lastEditedBy, summary string) (*Article, error) {
 	slug, err := GenerateUniqueSlug(title, 0)
 	if err != nil {
 		return nil, err
 	}
 
 	article := &Article{}
-	err = database.DB.QueryRow(
-		`INSERT INTO articles (slug, title, content, category_id, last_edited_by)
-		 VALUES ($1, $2, $3, $4, $5)
-		 RETURNING id, slug, title, content, COALESCE(category_id, 0), last_edited_by, created_at, updated_at`,
-		slug, title, content, categoryID, lastEditedBy,
-	).Scan(&article.ID, &article.Slug, &article.Title, &article.Content,
-		&article.CategoryID, &article.LastEditedBy, &article.CreatedAt, &article.UpdatedAt)
+	err = database.WithTx(func(tx *sql.Tx) error {
+		if err := claimSlug(tx, slug); err != nil {
+			return err
+		}
+		err := tx.QueryRow(
+			`INSERT INTO articles (slug, title, content, category_id, last_edited_by)
+			 VALUES ($1, $2, $3, $4, $5)
+			 RETURNING id, slug, title, content, COALESCE(category_id, 0), last_edited_by, created_at, updated_at`,
+			slug, title, content, categoryID, lastEditedBy,
+		).Scan(&article.ID, &article.Slug, &article.Title, &article.Content,
+			&article.CategoryID, &article.LastEditedBy, &article.CreatedAt, &article.UpdatedAt)
+		if err != nil {
+			return err
+		}
+		if err := setArticleTags(tx, article.ID, tagIDs); err != nil {
+			return err
+		}
+		if err := setArticleLinks(tx, article.ID, content); err != nil {
+			return err
+		}
+		if err := setArticleMedia(tx, article.ID, content); err != nil {
+			return err
+		}
+		_, err = createRevision(tx, article.ID, summary)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
+	indexArticle(article)
 	return article, nil
 }
 
-func UpdateArticle(id int, title, content string, categoryID int, lastEditedBy string) (*Article, error) {
-	slug, err := GenerateUniqueSlug(title, id)
-	if err != nil {
-		return nil, err
-	}
+var ErrEditConflict = errors.New("article was changed since the base revision")
 
+// UpdateArticle saves a new version of an article. If baseRevisionID is
+// non-zero the save only succeeds while it is still the latest revision;
+// otherwise ErrEditConflict is returned and nothing is written.
+//
+// The slug is only regenerated when the title changes, and the previous slug
+// is kept as an alias so existing links keep working.
+func UpdateArticle(id, baseRevisionID int, title, content string, categoryID int, tagIDs []int, lastEditedBy, summary string) (*Article, error) {
 	article := &Article{}
-	err = database.DB.QueryRow(
-		`UPDATE articles
-		 SET slug = $1, title = $2, content = $3, category_id = $4,
-		     last_edited_by = $5, updated_at = NOW()
-		 WHERE id = $6
-		 RETURNING id, slug, title, content, COALESCE(category_id, 0), last_edited_by, created_at, updated_at`,
-		slug, title, content, categoryID, lastEditedBy, id,
-	).Scan(&article.ID, &article.Slug, &article.Title, &article.Content,
-		&article.CategoryID, &article.LastEditedBy, &article.CreatedAt, &article.UpdatedAt)
+	err := database.WithTx(func(tx *sql.Tx) error {
+		current, err := lockArticle(tx, id)
+		if err != nil {
+			return err
+		}
+		if baseRevisionID != 0 {
+			var latestID int
+			err := tx.QueryRow(
+				`SELECT COALESCE(MAX(id), 0) FROM article_revisions WHERE article_id = $1`, id,
+			).Scan(&latestID)
+			if err != nil {
+				return err
+			}
+			if latestID != baseRevisionID {
+				return ErrEditConflict
+			}
+		}
+
+		slug := current.Slug
+		if title != current.Title {
+			slug, err = uniqueTitleSlug(tx, title, id)
+			if err != nil {
+				return err
+			}
+		}
+
+		if slug != current.Slug {
+			if err := claimSlug(tx, slug); err != nil {
+				return err
+			}
+			if err := addSlugAlias(tx, current.Slug, id, lastEditedBy); err != nil {
+				return err
+			}
+		}
+
+		err = tx.QueryRow(
+			`UPDATE articles
+			 SET slug = $1, title = $2, content = $3, category_id = $4,
+			     last_edited_by = $5, updated_at = NOW()
+			 WHERE id = $6
+			 RETURNING id, slug, title, content, COALESCE(category_id, 0), last_edited_by, created_at, updated_at`,
+			slug, title, content, categoryID, lastEditedBy, id,
+		).Scan(&article.ID, &article.Slug, &article.Title, &article.Content,
+			&article.CategoryID, &article.LastEditedBy, &article.CreatedAt, &article.UpdatedAt)
+		if err != nil {
+			return err
+		}
+		if err := setArticleTags(tx, article.ID, tagIDs); err != nil {
+			return err
+		}
+		if err := setArticleLinks(tx, article.ID, content); err != nil {
+			return err
+		}
+		if err := setArticleMedia(tx, article.ID, content); err != nil {
+			return err
+		}
+		_, err = createRevision(tx, article.ID, summary)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
+	indexArticle(article)
 	return article, nil
 }
 
 func SetArticleTags(articleID int, tagIDs []int) error {
-	_, err := database.DB.Exec(`DELETE FROM article_tags WHERE article_id = $1`, articleID)
+	return setArticleTags(database.DB, articleID, tagIDs)
+}
+
+func setArticleTags(q queryer, articleID int, tagIDs []int) error {
+	_, err := q.Exec(`DELETE FROM article_tags WHERE article_id = $1`, articleID)
 	if err != nil {
 		return err
 	}
 	for _, tagID := range tagIDs {
-		_, err := database.DB.Exec(
+		_, err := q.Exec(
 			`INSERT INTO article_tags (article_id, tag_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
 			articleID, tagID,
 		)
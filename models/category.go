@@ -45,7 +45,7 @@ func GetCategoriesWithArticleCount() ([]CategoryWithCount, error) {
 	rows, err := database.DB.Query(`
 		SELECT c.id, c.slug, c.name, c.description, c.created_at, COUNT(a.id) as article_count
 		FROM categories c
-		LEFT JOIN articles a ON a.category_id = c.id
+		LEFT JOIN articles a ON a.category_id = c.id AND a.deleted_at IS NULL
 		GROUP BY c.id, c.slug, c.name, c.description, c.created_at
 		ORDER BY c.name`,
 	)
@@ -104,7 +104,7 @@ func GetArticlesByCategory(categoryID int) ([]Article, error) {
 	rows, err := database.DB.Query(
 		`SELECT id, slug, title, content, created_at, updated_at
 		FROM articles
-		WHERE category_id = $1
+		WHERE category_id = $1 AND deleted_at IS NULL
 		ORDER BY title`,
 		categoryID,
 	)
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"silic0n-wiki/database"
+	"silic0n-wiki/markup"
+)
+
+// Backlink is an article that links to another one.
+type Backlink struct {
+	ArticleID    int
+	Slug         string
+	Title        string
+	LastEditedBy string
+	UpdatedAt    time.Time
+	TargetSlug   string
+}
+
+// setArticleLinks replaces the stored outgoing links of an article with the
+// ones found in content.
+func setArticleLinks(q queryer, articleID int, content string) error {
+	_, err := q.Exec(`DELETE FROM article_links WHERE article_id = $1`, articleID)
+	if err != nil {
+		return err
+	}
+	slugs := markup.Links(content)
+	if len(slugs) == 0 {
+		return nil
+	}
+	_, err = q.Exec(
+		`INSERT INTO article_links (article_id, target_slug)
+		 SELECT $1, unnest($2::text[])
+		 ON CONFLICT DO NOTHING`,
+		articleID, pq.Array(slugs),
+	)
+	return err
+}
+
+// GetBacklinks returns the articles linking to an article, either by its
+// current slug or by one of its aliases.
+func GetBacklinks(articleID int) ([]Backlink, error) {
+	rows, err := database.DB.Query(
+		`SELECT a.id, a.slug, a.title, a.last_edited_by, a.updated_at, l.target_slug
+		 FROM article_links l
+		 JOIN articles a ON a.id = l.article_id
+		 WHERE a.id != $1 AND a.deleted_at IS NULL
+		   AND (l.target_slug = (SELECT slug FROM articles WHERE id = $1)
+		        OR l.target_slug IN (SELECT slug FROM slug_aliases WHERE article_id = $1))
+		 ORDER BY a.title, l.target_slug`,
+		articleID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanBacklinks(rows)
+}
+
+// GetLinksToSlug returns the articles linking to exactly slug, excluding the
+// article with id excludeID.
+func GetLinksToSlug(slug string, excludeID int) ([]Backlink, error) {
+	rows, err := database.DB.Query(
+		`SELECT a.id, a.slug, a.title, a.last_edited_by, a.updated_at, l.target_slug
+		 FROM article_links l
+		 JOIN articles a ON a.id = l.article_id
+		 WHERE l.target_slug = $1 AND a.id != $2 AND a.deleted_at IS NULL
+		 ORDER BY a.title`,
+		slug, excludeID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanBacklinks(rows)
+}
+
+func scanBacklinks(rows *sql.Rows) ([]Backlink, error) {
+	defer rows.Close()
+
+	var links []Backlink
+	for rows.Next() {
+		var b Backlink
+		if err := rows.Scan(&b.ArticleID, &b.Slug, &b.Title, &b.LastEditedBy, &b.UpdatedAt, &b.TargetSlug); err != nil {
+			return nil, err
+		}
+		links = append(links, b)
+	}
+	return links, rows.Err()
+}
+
+// EnsureArticleLinks fills article_links from article content when the
+// table is empty, as it is right after the table is created.
+func EnsureArticleLinks() error {
+	var populated bool
+	err := database.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM article_links)`).Scan(&populated)
+	if err != nil || populated {
+		return err
+	}
+	return RebuildArticleLinks()
+}
+
+// RebuildArticleLinks re-extracts the outgoing links of every article.
+func RebuildArticleLinks() error {
+	articles, err := GetAllArticles()
+	if err != nil {
+		return err
+	}
+	for _, a := range articles {
+		if err := setArticleLinks(database.DB, a.ID, a.Content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,9 +1,19 @@
 package models
 
 import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/lib/pq"
 	"silic0n-wiki/database"
+	"silic0n-wiki/markup"
+	"silic0n-wiki/mediafile"
+	"silic0n-wiki/thumbnail"
 )
 
 type Media struct {
@@ -11,36 +21,95 @@ type Media struct {
 	ArticleID    *int
 	Filename     string
 	OriginalName string
-	FilePath     string
-	MimeType     string
-	FileSize     int64
-	UploadedBy   string
-	CreatedAt    time.Time
+	// FilePath is where the file is stored, relative to the upload
+	// directory. Files from before content-addressed storage have an
+	// empty SHA256 and live at the top of the upload directory instead.
+	FilePath string
+	MimeType string
+	FileSize int64
+	SHA256   string
+	// Width and Height are the pixel size of an image, or 0 if unknown.
+	Width      int
+	Height     int
+	UploadedBy string
+	CreatedAt  time.Time
 }
 
-func CreateMedia(articleID *int, filename, originalName, filePath, mimeType string, fileSize int64, uploadedBy string) (*Media, error) {
+const mediaColumns = `id, article_id, filename, original_name, file_path, mime_type, file_size,
+	COALESCE(sha256, ''), COALESCE(width, 0), COALESCE(height, 0), uploaded_by, created_at`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanMedia(row scanner, m *Media) error {
+	return row.Scan(&m.ID, &m.ArticleID, &m.Filename, &m.OriginalName,
+		&m.FilePath, &m.MimeType, &m.FileSize, &m.SHA256, &m.Width, &m.Height, &m.UploadedBy, &m.CreatedAt)
+}
+
+// MediaStoragePath is where a file with the given SHA-256 is stored,
+// relative to the upload directory. Two levels of directories named after
+// the start of the hash keep any one directory small.
+func MediaStoragePath(sum, ext string) string {
+	return filepath.Join(sum[:2], sum[2:4], sum+ext)
+}
+
+// StoredPath is the location of the file on disk.
+func (m Media) StoredPath(uploadDir string) string {
+	if m.SHA256 == "" {
+		return filepath.Join(uploadDir, m.Filename)
+	}
+	return filepath.Join(uploadDir, m.FilePath)
+}
+
+// CreateMedia records an uploaded file stored at filePath, relative to the
+// upload directory. width and height are 0 for files that are not images
+// or could not be measured. If the same file was recorded in the meantime,
+// that record is returned instead.
+func CreateMedia(articleID *int, filename, originalName, filePath, mimeType string, fileSize int64, sha256 string, width, height int, uploadedBy string) (*Media, error) {
 	media := &Media{}
-	err := database.DB.QueryRow(
-		`INSERT INTO media (article_id, filename, original_name, file_path, mime_type, file_size, uploaded_by)
-		 VALUES ($1, $2, $3, $4, $5, $6, $7)
-		 RETURNING id, article_id, filename, original_name, file_path, mime_type, file_size, uploaded_by, created_at`,
-		articleID, filename, originalName, filePath, mimeType, fileSize, uploadedBy,
-	).Scan(&media.ID, &media.ArticleID, &media.Filename, &media.OriginalName,
-		&media.FilePath, &media.MimeType, &media.FileSize, &media.UploadedBy, &media.CreatedAt)
+	err := scanMedia(database.DB.QueryRow(
+		`INSERT INTO media (article_id, filename, original_name, file_path, mime_type, file_size, sha256, width, height, uploaded_by)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		 ON CONFLICT (sha256) WHERE left(filename, 64) = sha256 DO NOTHING
+		 RETURNING `+mediaColumns,
+		articleID, filename, originalName, filePath, mimeType, fileSize, sha256, width, height, uploadedBy,
+	), media)
+	if err == sql.ErrNoRows {
+		return GetMediaBySHA256(sha256)
+	}
 	if err != nil {
 		return nil, err
 	}
 	return media, nil
 }
 
+// LinkMedia records that an article uses a media file that was uploaded
+// before, attaching the file to the article if it belongs to none yet.
+func LinkMedia(mediaID, articleID int) error {
+	return database.WithTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(
+			`UPDATE media SET article_id = $1 WHERE id = $2 AND article_id IS NULL`,
+			articleID, mediaID,
+		)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(
+			`INSERT INTO article_media (article_id, media_id) VALUES ($1, $2)
+			 ON CONFLICT DO NOTHING`,
+			articleID, mediaID,
+		)
+		return err
+	})
+}
+
 func GetMediaByFilename(filename string) (*Media, error) {
 	media := &Media{}
-	err := database.DB.QueryRow(
-		`SELECT id, article_id, filename, original_name, file_path, mime_type, file_size, uploaded_by, created_at
-		 FROM media WHERE filename = $1`,
+	err := scanMedia(database.DB.QueryRow(
+		`SELECT `+mediaColumns+` FROM media WHERE filename = $1`,
 		filename,
-	).Scan(&media.ID, &media.ArticleID, &media.Filename, &media.OriginalName,
-		&media.FilePath, &media.MimeType, &media.FileSize, &media.UploadedBy, &media.CreatedAt)
+	), media)
 	if err != nil {
 		return nil, err
 	}
@@ -49,12 +118,24 @@ func GetMediaByFilename(filename string) (*Media, error) {
 
 func GetMediaByID(id int) (*Media, error) {
 	media := &Media{}
-	err := database.DB.QueryRow(
-		`SELECT id, article_id, filename, original_name, file_path, mime_type, file_size, uploaded_by, created_at
-		 FROM media WHERE id = $1`,
+	err := scanMedia(database.DB.QueryRow(
+		`SELECT `+mediaColumns+` FROM media WHERE id = $1`,
 		id,
-	).Scan(&media.ID, &media.ArticleID, &media.Filename, &media.OriginalName,
-		&media.FilePath, &media.MimeType, &media.FileSize, &media.UploadedBy, &media.CreatedAt)
+	), media)
+	if err != nil {
+		return nil, err
+	}
+	return media, nil
+}
+
+// GetMediaBySHA256 returns the oldest media record for a file with the given
+// content hash.
+func GetMediaBySHA256(sum string) (*Media, error) {
+	media := &Media{}
+	err := scanMedia(database.DB.QueryRow(
+		`SELECT `+mediaColumns+` FROM media WHERE sha256 = $1 ORDER BY id LIMIT 1`,
+		sum,
+	), media)
 	if err != nil {
 		return nil, err
 	}
@@ -63,8 +144,7 @@ func GetMediaByID(id int) (*Media, error) {
 
 func GetMediaForArticle(articleID int) ([]Media, error) {
 	rows, err := database.DB.Query(
-		`SELECT id, article_id, filename, original_name, file_path, mime_type, file_size, uploaded_by, created_at
-		 FROM media WHERE article_id = $1 ORDER BY created_at DESC`,
+		`SELECT `+mediaColumns+` FROM media WHERE article_id = $1 ORDER BY created_at DESC`,
 		articleID,
 	)
 	if err != nil {
@@ -75,11 +155,321 @@ func GetMediaForArticle(articleID int) ([]Media, error) {
 	var mediaList []Media
 	for rows.Next() {
 		var m Media
-		if err := rows.Scan(&m.ID, &m.ArticleID, &m.Filename, &m.OriginalName,
-			&m.FilePath, &m.MimeType, &m.FileSize, &m.UploadedBy, &m.CreatedAt); err != nil {
+		if err := scanMedia(rows, &m); err != nil {
+			return nil, err
+		}
+		mediaList = append(mediaList, m)
+	}
+	return mediaList, rows.Err()
+}
+
+// GetMediaByFilenames returns the media records for filenames, keyed by
+// file name. Unknown names are left out.
+func GetMediaByFilenames(filenames []string) (map[string]Media, error) {
+	found := make(map[string]Media)
+	if len(filenames) == 0 {
+		return found, nil
+	}
+	rows, err := database.DB.Query(
+		`SELECT `+mediaColumns+` FROM media WHERE filename = ANY($1)`,
+		pq.Array(filenames),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var m Media
+		if err := scanMedia(rows, &m); err != nil {
+			return nil, err
+		}
+		found[m.Filename] = m
+	}
+	return found, rows.Err()
+}
+
+// EnsureMediaDimensions measures the images uploaded before dimensions
+// were recorded. Files that cannot be measured are stored as 0x0 so they
+// are not retried.
+func EnsureMediaDimensions(uploadDir string) error {
+	todo, err := queryMedia(`SELECT ` + mediaColumns + ` FROM media WHERE width IS NULL AND mime_type LIKE 'image/%'`)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range todo {
+		width, height, err := thumbnail.Dimensions(m.StoredPath(uploadDir))
+		if err != nil {
+			log.Printf("Cannot measure media %s: %v", m.Filename, err)
+		}
+		_, err = database.DB.Exec(
+			`UPDATE media SET width = $1, height = $2 WHERE id = $3`, width, height, m.ID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SizeLabel formats the file size for display, e.g. "1.5 MB".
+func (m Media) SizeLabel() string {
+	switch {
+	case m.FileSize < 1024:
+		return fmt.Sprintf("%d B", m.FileSize)
+	case m.FileSize < 1024*1024:
+		return fmt.Sprintf("%.1f KB", float64(m.FileSize)/1024)
+	default:
+		return fmt.Sprintf("%.1f MB", float64(m.FileSize)/(1024*1024))
+	}
+}
+
+func (m Media) IsImage() bool {
+	return strings.HasPrefix(m.MimeType, "image/")
+}
+
+func (m Media) IsVideo() bool {
+	return strings.HasPrefix(m.MimeType, "video/")
+}
+
+// setArticleMedia replaces the stored media embeds of an article with the
+// ones found in content. Embedded files not yet attached to any article
+// are attached to this one.
+func setArticleMedia(q queryer, articleID int, content string) error {
+	_, err := q.Exec(`DELETE FROM article_media WHERE article_id = $1`, articleID)
+	if err != nil {
+		return err
+	}
+	filenames := markup.Media(content)
+	if len(filenames) == 0 {
+		return nil
+	}
+	_, err = q.Exec(
+		`INSERT INTO article_media (article_id, media_id)
+		 SELECT $1, id FROM media WHERE filename = ANY($2)
+		 ON CONFLICT DO NOTHING`,
+		articleID, pq.Array(filenames),
+	)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(
+		`UPDATE media SET article_id = $1 WHERE filename = ANY($2) AND article_id IS NULL`,
+		articleID, pq.Array(filenames),
+	)
+	return err
+}
+
+// EnsureArticleMedia fills article_media from article content when the
+// table is empty, as it is right after the table is created.
+func EnsureArticleMedia() error {
+	var populated bool
+	err := database.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM article_media)`).Scan(&populated)
+	if err != nil || populated {
+		return err
+	}
+	return RebuildArticleMedia()
+}
+
+// RebuildArticleMedia re-extracts the media embeds of every article.
+func RebuildArticleMedia() error {
+	articles, err := GetAllArticles()
+	if err != nil {
+		return err
+	}
+	for _, a := range articles {
+		if err := setArticleMedia(database.DB, a.ID, a.Content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Media type filters for the media library.
+const (
+	MediaTypeImage = "image"
+	MediaTypeVideo = "video"
+)
+
+// MediaFilter selects files for the media library. Query matches the
+// original file name; Type is empty or one of the MediaType constants.
+type MediaFilter struct {
+	Query  string
+	Type   string
+	Limit  int
+	Offset int
+}
+
+// MediaUse is an article that embeds a media file.
+type MediaUse struct {
+	Slug  string
+	Title string
+}
+
+// MediaItem is a media library entry.
+type MediaItem struct {
+	Media
+	UsedBy []MediaUse
+}
+
+// ListMedia returns one page of the media library, newest first, along
+// with the total number of matching files.
+func ListMedia(f MediaFilter) ([]MediaItem, int, error) {
+	var clauses []string
+	var args []interface{}
+	if f.Query != "" {
+		args = append(args, "%"+database.EscapeLike(f.Query)+"%")
+		clauses = append(clauses, fmt.Sprintf(`original_name ILIKE $%d ESCAPE '\'`, len(args)))
+	}
+	if f.Type == MediaTypeImage || f.Type == MediaTypeVideo {
+		args = append(args, f.Type+"/%")
+		clauses = append(clauses, fmt.Sprintf("mime_type LIKE $%d", len(args)))
+	}
+	where := ""
+	if len(clauses) > 0 {
+		where = "WHERE " + strings.Join(clauses, " AND ")
+	}
+
+	var total int
+	if err := database.DB.QueryRow(`SELECT COUNT(*) FROM media `+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	args = append(args, f.Limit, f.Offset)
+	rows, err := database.DB.Query(
+		fmt.Sprintf(`SELECT `+mediaColumns+`
+		 FROM media %s
+		 ORDER BY created_at DESC, id DESC
+		 LIMIT $%d OFFSET $%d`, where, len(args)-1, len(args)),
+		args...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var items []MediaItem
+	var ids []int
+	for rows.Next() {
+		var m MediaItem
+		if err := scanMedia(rows, &m.Media); err != nil {
+			return nil, 0, err
+		}
+		items = append(items, m)
+		ids = append(ids, m.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	uses, err := getMediaUses(ids)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range items {
+		items[i].UsedBy = uses[items[i].ID]
+	}
+	return items, total, nil
+}
+
+// getMediaUses returns the live articles embedding each of the media ids.
+func getMediaUses(ids []int) (map[int][]MediaUse, error) {
+	uses := make(map[int][]MediaUse)
+	if len(ids) == 0 {
+		return uses, nil
+	}
+	rows, err := database.DB.Query(
+		`SELECT am.media_id, a.slug, a.title
+		 FROM article_media am
+		 JOIN articles a ON a.id = am.article_id
+		 WHERE am.media_id = ANY($1) AND a.deleted_at IS NULL
+		 ORDER BY a.title`,
+		pq.Array(ids),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var u MediaUse
+		if err := rows.Scan(&id, &u.Slug, &u.Title); err != nil {
+			return nil, err
+		}
+		uses[id] = append(uses[id], u)
+	}
+	return uses, rows.Err()
+}
+
+func queryMedia(query string, args ...any) ([]Media, error) {
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mediaList []Media
+	for rows.Next() {
+		var m Media
+		if err := scanMedia(rows, &m); err != nil {
 			return nil, err
 		}
 		mediaList = append(mediaList, m)
 	}
 	return mediaList, rows.Err()
 }
+
+// MigrateMediaStorage moves files uploaded before content-addressed storage
+// into it. Each file is hashed, linked into place at its MediaStoragePath
+// and recorded with its hash before the old copy is removed, so it can be
+// interrupted and run again. Files with identical content end up sharing
+// one stored copy; their records, and so their URLs, are kept. It returns
+// how many files were moved and how many turned out to be duplicates.
+func MigrateMediaStorage(uploadDir, thumbDir string) (moved, shared int, err error) {
+	legacy, err := queryMedia(`SELECT ` + mediaColumns + ` FROM media WHERE sha256 IS NULL ORDER BY id`)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, m := range legacy {
+		src := m.StoredPath(uploadDir)
+		sum, err := mediafile.SHA256(src)
+		if os.IsNotExist(err) {
+			log.Printf("Skipping media %d: %s is missing", m.ID, src)
+			continue
+		}
+		if err != nil {
+			return moved, shared, err
+		}
+
+		rel := MediaStoragePath(sum, filepath.Ext(m.Filename))
+		dst := filepath.Join(uploadDir, rel)
+		if _, err := os.Stat(dst); err == nil {
+			shared++
+		} else {
+			if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+				return moved, shared, err
+			}
+			if err := os.Link(src, dst); err != nil {
+				return moved, shared, err
+			}
+			moved++
+		}
+
+		_, err = database.DB.Exec(
+			`UPDATE media SET sha256 = $1, file_path = $2 WHERE id = $3`, sum, rel, m.ID,
+		)
+		if err != nil {
+			return moved, shared, err
+		}
+		if err := os.Remove(src); err != nil {
+			log.Printf("Cannot remove migrated file %s: %v", src, err)
+		}
+		if err := thumbnail.MoveUnsharded(thumbDir, m.Filename); err != nil {
+			log.Printf("Cannot move thumbnails of %s: %v", m.Filename, err)
+		}
+	}
+	return moved, shared, nil
+}
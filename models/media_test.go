@@ -0,0 +1,158 @@
+package models
+
+import (
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"silic0n-wiki/database"
+)
+
+const testSum = "ab12cd34ef56ab12cd34ef56ab12cd34ef56ab12cd34ef56ab12cd34ef56ab12"
+
+func TestMediaStoragePath(t *testing.T) {
+	want := filepath.Join("ab", "12", testSum+".png")
+	if got := MediaStoragePath(testSum, ".png"); got != want {
+		t.Errorf("MediaStoragePath = %q, want %q", got, want)
+	}
+}
+
+func TestStoredPath(t *testing.T) {
+	tests := []struct {
+		name  string
+		media Media
+		want  string
+	}{
+		{"legacy", Media{Filename: "1234-abcd.png", FilePath: "uploads/1234-abcd.png"}, filepath.Join("up", "1234-abcd.png")},
+		{"content addressed", Media{Filename: testSum + ".png", FilePath: MediaStoragePath(testSum, ".png"), SHA256: testSum},
+			filepath.Join("up", "ab", "12", testSum+".png")},
+		{"migrated legacy", Media{Filename: "1234-abcd.png", FilePath: MediaStoragePath(testSum, ".png"), SHA256: testSum},
+			filepath.Join("up", "ab", "12", testSum+".png")},
+	}
+	for _, tt := range tests {
+		if got := tt.media.StoredPath("up"); got != tt.want {
+			t.Errorf("%s: StoredPath = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func createTestMedia(articleID *int, sum, filename, uploadedBy string) (*Media, error) {
+	return CreateMedia(articleID, filename, "photo.png", MediaStoragePath(sum, ".png"), "image/png",
+		100, sum, 10, 10, uploadedBy)
+}
+
+func TestCreateMediaDeduplicates(t *testing.T) {
+	useTestDB(t)
+
+	first, err := createTestMedia(nil, testSum, testSum+".png", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := createTestMedia(nil, testSum, testSum+".png", "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != first.ID || again.UploadedBy != "alice" {
+		t.Errorf("second upload = %+v, want the first record %+v", again, first)
+	}
+
+	// Uploads racing each other all end up with the one record.
+	other := strings.Repeat("9", 64)
+	ids := make([]int, 8)
+	var wg sync.WaitGroup
+	for i := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m, err := createTestMedia(nil, other, other+".png", "carol")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			ids[i] = m.ID
+		}()
+	}
+	wg.Wait()
+	for _, id := range ids {
+		if id != ids[0] {
+			t.Fatalf("concurrent uploads made several records: %v", ids)
+		}
+	}
+	var count int
+	if err := database.DB.QueryRow(`SELECT COUNT(*) FROM media WHERE sha256 = $1`, other).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("%d records for one file, want 1", count)
+	}
+}
+
+// Files from before content-addressed storage keep their own records, and
+// names, when they turn out to have the same content.
+func TestCreateMediaKeepsLegacyDuplicates(t *testing.T) {
+	useTestDB(t)
+
+	a, err := createTestMedia(nil, testSum, "1111-legacy.png", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := createTestMedia(nil, testSum, "2222-legacy.png", "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.ID == b.ID {
+		t.Error("legacy records with the same content were merged")
+	}
+	found, err := GetMediaBySHA256(testSum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.ID != a.ID {
+		t.Errorf("GetMediaBySHA256 = %d, want the oldest record %d", found.ID, a.ID)
+	}
+}
+
+func TestLinkMedia(t *testing.T) {
+	useTestDB(t)
+	category := testCategoryID(t)
+
+	first, err := CreateArticle("First", "text", category, nil, "alice", "created")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := CreateArticle("Second", "text", category, nil, "alice", "created")
+	if err != nil {
+		t.Fatal(err)
+	}
+	media, err := createTestMedia(nil, testSum, testSum+".png", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := LinkMedia(media.ID, first.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := LinkMedia(media.ID, second.ID); err != nil {
+		t.Fatal(err)
+	}
+	// Linking twice is harmless.
+	if err := LinkMedia(media.ID, second.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := GetMediaByID(media.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ArticleID == nil || *got.ArticleID != first.ID {
+		t.Errorf("media belongs to %v, want the first article %d", got.ArticleID, first.ID)
+	}
+	uses, err := getMediaUses([]int{media.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uses[media.ID]) != 2 {
+		t.Errorf("media used by %+v, want both articles", uses[media.ID])
+	}
+}
@@ -0,0 +1,138 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"silic0n-wiki/database"
+)
+
+// ProtectionLevel restricts who may edit an article.
+type ProtectionLevel string
+
+const (
+	ProtectionNone       ProtectionLevel = "none"
+	ProtectionModerators ProtectionLevel = "moderators"
+	ProtectionAdmins     ProtectionLevel = "admins"
+)
+
+// ProtectionLevels lists every level from least to most restrictive.
+var ProtectionLevels = []ProtectionLevel{ProtectionNone, ProtectionModerators, ProtectionAdmins}
+
+func (l ProtectionLevel) Valid() bool {
+	return l == ProtectionNone || l == ProtectionModerators || l == ProtectionAdmins
+}
+
+// MinimumRole is the lowest role that may edit at this level.
+func (l ProtectionLevel) MinimumRole() Role {
+	switch l {
+	case ProtectionAdmins:
+		return RoleAdmin
+	case ProtectionModerators:
+		return RoleModerator
+	default:
+		return RoleEditor
+	}
+}
+
+func (l ProtectionLevel) Label() string {
+	switch l {
+	case ProtectionModerators:
+		return "Moderators only"
+	case ProtectionAdmins:
+		return "Admins only"
+	default:
+		return "Unprotected"
+	}
+}
+
+// Protection is an article's protection setting. An expired protection is
+// kept as stored but no longer applies.
+type Protection struct {
+	Level     ProtectionLevel
+	ExpiresAt sql.NullTime
+}
+
+// Current returns the level in force now.
+func (p Protection) Current() ProtectionLevel {
+	if p.Level == "" || p.ExpiresAt.Valid && !p.ExpiresAt.Time.After(time.Now()) {
+		return ProtectionNone
+	}
+	return p.Level
+}
+
+func (p Protection) Active() bool {
+	return p.Current() != ProtectionNone
+}
+
+// CanEdit reports whether the user may edit an article with protection p.
+func (u *User) CanEdit(p Protection) bool {
+	return u.Can(PermArticleEdit) && u.Role.AtLeast(p.Current().MinimumRole())
+}
+
+// CanProtect reports whether the user may change protection from p to
+// level. Nobody can set or lift a level they could not edit under.
+func (u *User) CanProtect(p Protection, level ProtectionLevel) bool {
+	return u.Can(PermArticleProtect) && u.CanEdit(p) && u.Role.AtLeast(level.MinimumRole())
+}
+
+type ProtectionLogEntry struct {
+	ID        int
+	ArticleID int
+	Level     ProtectionLevel
+	ExpiresAt sql.NullTime
+	ChangedBy string
+	Reason    string
+	CreatedAt time.Time
+}
+
+// SetProtection changes an article's protection and logs who changed it
+// and why. A zero expiresAt protects indefinitely.
+func SetProtection(articleID int, level ProtectionLevel, expiresAt time.Time, changedBy, reason string) error {
+	expires := sql.NullTime{Time: expiresAt, Valid: !expiresAt.IsZero() && level != ProtectionNone}
+	return database.WithTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec(
+			`UPDATE articles SET protection = $1, protection_expires_at = $2 WHERE id = $3`,
+			level, expires, articleID,
+		)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return sql.ErrNoRows
+		}
+		_, err = tx.Exec(
+			`INSERT INTO protection_log (article_id, protection, expires_at, changed_by, reason)
+			 VALUES ($1, $2, $3, $4, $5)`,
+			articleID, level, expires, changedBy, reason,
+		)
+		return err
+	})
+}
+
+func GetProtectionLog(articleID int) ([]ProtectionLogEntry, error) {
+	rows, err := database.DB.Query(
+		`SELECT id, article_id, protection, expires_at, changed_by, reason, created_at
+		 FROM protection_log
+		 WHERE article_id = $1
+		 ORDER BY created_at DESC, id DESC`,
+		articleID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []ProtectionLogEntry
+	for rows.Next() {
+		var e ProtectionLogEntry
+		if err := rows.Scan(&e.ID, &e.ArticleID, &e.Level, &e.ExpiresAt, &e.ChangedBy,
+			&e.Reason, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,96 @@
+package models
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestProtectionCurrent(t *testing.T) {
+	past := sql.NullTime{Time: time.Now().Add(-time.Hour), Valid: true}
+	future := sql.NullTime{Time: time.Now().Add(time.Hour), Valid: true}
+	tests := []struct {
+		name string
+		p    Protection
+		want ProtectionLevel
+	}{
+		{"unset", Protection{}, ProtectionNone},
+		{"indefinite", Protection{Level: ProtectionModerators}, ProtectionModerators},
+		{"not expired", Protection{Level: ProtectionAdmins, ExpiresAt: future}, ProtectionAdmins},
+		{"expired", Protection{Level: ProtectionAdmins, ExpiresAt: past}, ProtectionNone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.p.Current(); got != tt.want {
+				t.Errorf("Current() = %q, want %q", got, tt.want)
+			}
+			if got := tt.p.Active(); got != (tt.want != ProtectionNone) {
+				t.Errorf("Active() = %v", got)
+			}
+		})
+	}
+}
+
+func TestCanEdit(t *testing.T) {
+	// Each row lists, for ProtectionLevels in order, whether the role may
+	// edit.
+	matrix := map[Role][]bool{
+		RoleReader:    {false, false, false},
+		RoleEditor:    {true, false, false},
+		RoleModerator: {true, true, false},
+		RoleAdmin:     {true, true, true},
+	}
+	for role, want := range matrix {
+		u := &User{Role: role}
+		for i, level := range ProtectionLevels {
+			if got := u.CanEdit(Protection{Level: level}); got != want[i] {
+				t.Errorf("%s CanEdit(%s) = %v, want %v", role, level, got, want[i])
+			}
+		}
+	}
+
+	expired := Protection{Level: ProtectionAdmins, ExpiresAt: sql.NullTime{Time: time.Now().Add(-time.Minute), Valid: true}}
+	if !(&User{Role: RoleEditor}).CanEdit(expired) {
+		t.Error("an expired protection still applies")
+	}
+}
+
+func TestCanProtect(t *testing.T) {
+	none := Protection{Level: ProtectionNone}
+	moderators := Protection{Level: ProtectionModerators}
+	admins := Protection{Level: ProtectionAdmins}
+	tests := []struct {
+		role  Role
+		from  Protection
+		to    ProtectionLevel
+		allow bool
+	}{
+		{RoleEditor, none, ProtectionModerators, false},
+		{RoleModerator, none, ProtectionModerators, true},
+		{RoleModerator, moderators, ProtectionNone, true},
+		{RoleModerator, none, ProtectionAdmins, false},
+		{RoleModerator, admins, ProtectionNone, false},
+		{RoleAdmin, none, ProtectionAdmins, true},
+		{RoleAdmin, admins, ProtectionModerators, true},
+	}
+	for _, tt := range tests {
+		u := &User{Role: tt.role}
+		if got := u.CanProtect(tt.from, tt.to); got != tt.allow {
+			t.Errorf("%s CanProtect(%s -> %s) = %v, want %v", tt.role, tt.from.Level, tt.to, got, tt.allow)
+		}
+	}
+}
+
+func TestProtectionLevelValid(t *testing.T) {
+	for _, l := range ProtectionLevels {
+		if !l.Valid() {
+			t.Errorf("%s is not valid", l)
+		}
+	}
+	// "editors" was the old name of the moderators level.
+	for _, l := range []ProtectionLevel{"", "editors", "everyone"} {
+		if l.Valid() {
+			t.Errorf("%q is valid", l)
+		}
+	}
+}
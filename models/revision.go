@@ -0,0 +1,355 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"silic0n-wiki/database"
+	"silic0n-wiki/diff"
+)
+
+type Revision struct {
+	ID           int
+	ArticleID    int
+	Title        string
+	Content      string
+	CategoryID   int
+	CategoryName string
+	CategorySlug string
+	Tags         []string
+	EditedBy     string
+	Summary      string
+	CreatedAt    time.Time
+}
+
+type RevisionDiff struct {
+	From            *Revision
+	To              *Revision
+	TitleChanged    bool
+	CategoryChanged bool
+	TagsAdded       []string
+	TagsRemoved     []string
+	Hunks           []diff.Hunk
+}
+
+// HasChanges reports whether the two revisions differ in any tracked field.
+func (d *RevisionDiff) HasChanges() bool {
+	return d.TitleChanged || d.CategoryChanged || len(d.TagsAdded) > 0 ||
+		len(d.TagsRemoved) > 0 || len(d.Hunks) > 0
+}
+
+// ArticleEdit is the state of an article as submitted by an editor.
+type ArticleEdit struct {
+	Title      string
+	Content    string
+	CategoryID int
+	Tags       []string
+}
+
+// EditMerge is the result of merging an edit made against an old revision
+// with the changes saved since.
+type EditMerge struct {
+	Base          *Revision
+	Theirs        *Revision
+	Merged        ArticleEdit
+	ContentMerge  *diff.MergeResult
+	TitleConflict bool
+}
+
+func (m *EditMerge) HasConflicts() bool {
+	return m.TitleConflict || m.ContentMerge.HasConflicts()
+}
+
+type RevisionSummary struct {
+	ID        int
+	ArticleID int
+	Title     string
+	EditedBy  string
+	Summary   string
+	Size      int
+	CreatedAt time.Time
+}
+
+// createRevision snapshots the article's current row and tags. It must run in
+// the same transaction as the write it records.
+func createRevision(q queryer, articleID int, summary string) (int, error) {
+	var id int
+	err := q.QueryRow(
+		`INSERT INTO article_revisions (article_id, title, content, category_id, tags, edited_by, summary)
+		 SELECT a.id, a.title, a.content, a.category_id,
+		        ARRAY(SELECT t.name FROM article_tags at JOIN tags t ON t.id = at.tag_id
+		              WHERE at.article_id = a.id ORDER BY t.name),
+		        a.last_edited_by, $2
+		 FROM articles a
+		 WHERE a.id = $1
+		 RETURNING id`,
+		articleID, summary,
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func GetRevision(id int) (*Revision, error) {
+	rev := &Revision{}
+	err := database.DB.QueryRow(
+		`SELECT r.id, r.article_id, r.title, r.content, COALESCE(r.category_id, 0),
+		        COALESCE(c.name, ''), COALESCE(c.slug, ''), r.tags, r.edited_by, r.summary, r.created_at
+		 FROM article_revisions r
+		 LEFT JOIN categories c ON r.category_id = c.id
+		 WHERE r.id = $1`,
+		id,
+	).Scan(&rev.ID, &rev.ArticleID, &rev.Title, &rev.Content, &rev.CategoryID,
+		&rev.CategoryName, &rev.CategorySlug, pq.Array(&rev.Tags), &rev.EditedBy, &rev.Summary, &rev.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return rev, nil
+}
+
+func GetRevisionsForArticle(articleID int) ([]RevisionSummary, error) {
+	rows, err := database.DB.Query(
+		`SELECT id, article_id, title, edited_by, summary, LENGTH(content), created_at
+		 FROM article_revisions
+		 WHERE article_id = $1
+		 ORDER BY id DESC`,
+		articleID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []RevisionSummary
+	for rows.Next() {
+		var r RevisionSummary
+		if err := rows.Scan(&r.ID, &r.ArticleID, &r.Title, &r.EditedBy, &r.Summary, &r.Size, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+	return revisions, rows.Err()
+}
+
+// GetLatestRevisionID returns the newest revision of an article, or 0 if the
+// article has none.
+func GetLatestRevisionID(articleID int) (int, error) {
+	var id int
+	err := database.DB.QueryRow(
+		`SELECT COALESCE(MAX(id), 0) FROM article_revisions WHERE article_id = $1`,
+		articleID,
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// GetPreviousRevisionID returns the revision saved immediately before
+// revisionID, or 0 if revisionID is the first one.
+func GetPreviousRevisionID(articleID, revisionID int) (int, error) {
+	var id int
+	err := database.DB.QueryRow(
+		`SELECT COALESCE(MAX(id), 0) FROM article_revisions WHERE article_id = $1 AND id < $2`,
+		articleID, revisionID,
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func DiffRevisions(fromID, toID int) (*RevisionDiff, error) {
+	from, err := GetRevision(fromID)
+	if err != nil {
+		return nil, err
+	}
+	to, err := GetRevision(toID)
+	if err != nil {
+		return nil, err
+	}
+	if from.ArticleID != to.ArticleID {
+		return nil, sql.ErrNoRows
+	}
+	return CompareRevisions(from, to), nil
+}
+
+// CompareRevisions diffs the content and metadata of two revisions. Content
+// hunks carry three lines of context.
+func CompareRevisions(from, to *Revision) *RevisionDiff {
+	d := &RevisionDiff{
+		From:            from,
+		To:              to,
+		TitleChanged:    from.Title != to.Title,
+		CategoryChanged: from.CategoryID != to.CategoryID,
+		Hunks:           diff.Hunks(diff.Lines(from.Content, to.Content), 3),
+	}
+
+	oldTags := make(map[string]bool)
+	for _, t := range from.Tags {
+		oldTags[t] = true
+	}
+	newTags := make(map[string]bool)
+	for _, t := range to.Tags {
+		newTags[t] = true
+		if !oldTags[t] {
+			d.TagsAdded = append(d.TagsAdded, t)
+		}
+	}
+	for _, t := range from.Tags {
+		if !newTags[t] {
+			d.TagsRemoved = append(d.TagsRemoved, t)
+		}
+	}
+	sort.Strings(d.TagsAdded)
+	sort.Strings(d.TagsRemoved)
+
+	return d
+}
+
+var ErrNothingToRollback = errors.New("no earlier revision by another user to roll back to")
+
+// RevertArticle restores the title, content, category and tags stored in a
+// revision. The restore is saved as a new revision.
+func RevertArticle(articleID, revisionID int, editor string) (*Article, error) {
+	rev, err := GetRevision(revisionID)
+	if err != nil {
+		return nil, err
+	}
+	if rev.ArticleID != articleID {
+		return nil, sql.ErrNoRows
+	}
+
+	summary := fmt.Sprintf("Reverted to revision %d by %s", rev.ID, rev.EditedBy)
+	return restoreRevision(rev, 0, editor, summary)
+}
+
+// RollbackEdits reverts every consecutive edit made by username at the top of
+// the article's history, restoring the last revision saved by someone else.
+// If the article is edited while this runs, ErrEditConflict is returned and
+// nothing is written.
+func RollbackEdits(articleID int, username, editor string) (*Article, int, error) {
+	revisions, err := GetRevisionsForArticle(articleID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(revisions) == 0 || revisions[0].EditedBy != username {
+		return nil, 0, ErrNothingToRollback
+	}
+
+	count := 0
+	for _, r := range revisions {
+		if r.EditedBy != username {
+			break
+		}
+		count++
+	}
+	if count == len(revisions) {
+		return nil, 0, ErrNothingToRollback
+	}
+
+	target, err := GetRevision(revisions[count].ID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	edits := "edits"
+	if count == 1 {
+		edits = "edit"
+	}
+	summary := fmt.Sprintf("Rolled back %d %s by %s to revision %d by %s",
+		count, edits, username, target.ID, target.EditedBy)
+	article, err := restoreRevision(target, revisions[0].ID, editor, summary)
+	if err != nil {
+		return nil, 0, err
+	}
+	return article, count, nil
+}
+
+// restoreRevision saves rev as a new revision. A non-zero baseRevisionID is
+// passed on to UpdateArticle.
+func restoreRevision(rev *Revision, baseRevisionID int, editor, summary string) (*Article, error) {
+	categoryID := rev.CategoryID
+	if categoryID == 0 {
+		current, err := GetArticleByID(rev.ArticleID)
+		if err != nil {
+			return nil, err
+		}
+		categoryID = current.CategoryID
+	}
+
+	tagIDs, err := ResolveTagNames(strings.Join(rev.Tags, ","), categoryID)
+	if err != nil {
+		return nil, err
+	}
+
+	return UpdateArticle(rev.ArticleID, baseRevisionID, rev.Title, rev.Content, categoryID, tagIDs, editor, summary)
+}
+
+// MergeEdit three-way merges mine, written against baseID, with the changes
+// that produced theirsID. Content is merged line by line; title, category and
+// tags take whichever side changed them, and a title changed differently on
+// both sides is reported as a conflict.
+func MergeEdit(baseID, theirsID int, mine ArticleEdit) (*EditMerge, error) {
+	base, err := GetRevision(baseID)
+	if err != nil {
+		return nil, err
+	}
+	theirs, err := GetRevision(theirsID)
+	if err != nil {
+		return nil, err
+	}
+	if base.ArticleID != theirs.ArticleID {
+		return nil, sql.ErrNoRows
+	}
+
+	m := &EditMerge{
+		Base:         base,
+		Theirs:       theirs,
+		ContentMerge: diff.Merge3(base.Content, mine.Content, theirs.Content),
+	}
+	m.Merged.Content = m.ContentMerge.Text()
+
+	switch {
+	case mine.Title == theirs.Title || theirs.Title == base.Title:
+		m.Merged.Title = mine.Title
+	case mine.Title == base.Title:
+		m.Merged.Title = theirs.Title
+	default:
+		m.Merged.Title = mine.Title
+		m.TitleConflict = true
+	}
+
+	m.Merged.CategoryID = mine.CategoryID
+	if mine.CategoryID == base.CategoryID {
+		m.Merged.CategoryID = theirs.CategoryID
+	}
+
+	m.Merged.Tags = mine.Tags
+	if sameTagNames(mine.Tags, base.Tags) {
+		m.Merged.Tags = theirs.Tags
+	}
+
+	return m, nil
+}
+
+func sameTagNames(a, b []string) bool {
+	set := make(map[string]bool)
+	for _, name := range a {
+		set[Slugify(name)] = true
+	}
+	other := make(map[string]bool)
+	for _, name := range b {
+		if !set[Slugify(name)] {
+			return false
+		}
+		other[Slugify(name)] = true
+	}
+	return len(set) == len(other)
+}
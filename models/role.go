@@ -0,0 +1,71 @@
+package models
+
+// Role is a user's access level. Each role has every permission of the
+// roles below it.
+type Role string
+
+const (
+	RoleReader    Role = "reader"
+	RoleEditor    Role = "editor"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+// Roles lists every role from least to most privileged.
+var Roles = []Role{RoleReader, RoleEditor, RoleModerator, RoleAdmin}
+
+// Permission names an action that is restricted to some roles.
+type Permission string
+
+const (
+	PermArticleEdit    Permission = "article.edit"
+	PermArticleDelete  Permission = "article.delete"
+	PermArticleProtect Permission = "article.protect"
+	PermCategoryManage Permission = "category.manage"
+	PermMediaUpload    Permission = "media.upload"
+	PermReportsView    Permission = "reports.view"
+	PermUserManage     Permission = "user.manage"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleReader:    {},
+	RoleEditor:    {PermArticleEdit, PermMediaUpload},
+	RoleModerator: {PermArticleEdit, PermMediaUpload, PermArticleDelete, PermArticleProtect, PermCategoryManage, PermReportsView},
+	RoleAdmin:     {PermArticleEdit, PermMediaUpload, PermArticleDelete, PermArticleProtect, PermCategoryManage, PermReportsView, PermUserManage},
+}
+
+func (r Role) Valid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+// AtLeast reports whether r ranks at or above other.
+func (r Role) AtLeast(other Role) bool {
+	rank := func(role Role) int {
+		for i, candidate := range Roles {
+			if candidate == role {
+				return i
+			}
+		}
+		return -1
+	}
+	return rank(r) >= rank(other)
+}
+
+func (r Role) Has(p Permission) bool {
+	for _, granted := range rolePermissions[r] {
+		if granted == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Can reports whether the user may perform p. A nil user is an anonymous
+// visitor and may not perform anything restricted.
+func (u *User) Can(p Permission) bool {
+	if u == nil {
+		return false
+	}
+	return u.Role.Has(p)
+}
@@ -0,0 +1,71 @@
+package models
+
+import "testing"
+
+func TestRolePermissions(t *testing.T) {
+	perms := []Permission{PermArticleEdit, PermMediaUpload, PermArticleDelete, PermArticleProtect,
+		PermCategoryManage, PermReportsView, PermUserManage}
+	// Each row lists, in the order of perms, what the role may do.
+	matrix := map[Role][]bool{
+		RoleReader:    {false, false, false, false, false, false, false},
+		RoleEditor:    {true, true, false, false, false, false, false},
+		RoleModerator: {true, true, true, true, true, true, false},
+		RoleAdmin:     {true, true, true, true, true, true, true},
+		"bogus":       {false, false, false, false, false, false, false},
+	}
+	for role, want := range matrix {
+		for i, p := range perms {
+			if got := role.Has(p); got != want[i] {
+				t.Errorf("%s.Has(%s) = %v, want %v", role, p, got, want[i])
+			}
+			u := &User{Role: role}
+			if got := u.Can(p); got != want[i] {
+				t.Errorf("%s user Can(%s) = %v, want %v", role, p, got, want[i])
+			}
+		}
+	}
+}
+
+// Every role has every permission of the roles below it.
+func TestRolesAreCumulative(t *testing.T) {
+	for i := 1; i < len(Roles); i++ {
+		for _, p := range rolePermissions[Roles[i-1]] {
+			if !Roles[i].Has(p) {
+				t.Errorf("%s lacks %s, which %s has", Roles[i], p, Roles[i-1])
+			}
+		}
+	}
+}
+
+func TestRoleAtLeast(t *testing.T) {
+	for i, r := range Roles {
+		for j, other := range Roles {
+			if got := r.AtLeast(other); got != (i >= j) {
+				t.Errorf("%s.AtLeast(%s) = %v, want %v", r, other, got, i >= j)
+			}
+		}
+	}
+	if Role("bogus").AtLeast(RoleReader) {
+		t.Error("an unknown role ranks as a reader")
+	}
+}
+
+func TestRoleValid(t *testing.T) {
+	for _, r := range Roles {
+		if !r.Valid() {
+			t.Errorf("%s is not valid", r)
+		}
+	}
+	for _, r := range []Role{"", "root", "Admin"} {
+		if r.Valid() {
+			t.Errorf("%q is valid", r)
+		}
+	}
+}
+
+func TestAnonymousCannot(t *testing.T) {
+	var u *User
+	if u.Can(PermArticleEdit) {
+		t.Error("an anonymous visitor may edit")
+	}
+}
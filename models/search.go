@@ -0,0 +1,119 @@
+package models
+
+import (
+	"log"
+
+	"silic0n-wiki/database"
+	"silic0n-wiki/search"
+)
+
+// searchDocument builds the search index document for an article.
+func searchDocument(slug string) (search.Document, error) {
+	article, err := GetArticleBySlug(slug)
+	if err != nil {
+		return search.Document{}, err
+	}
+	tags, err := GetTagsForArticle(article.ID)
+	if err != nil {
+		return search.Document{}, err
+	}
+
+	doc := search.Document{
+		ID:           article.ID,
+		Slug:         article.Slug,
+		Title:        article.Title,
+		Content:      article.Content,
+		CategorySlug: article.CategorySlug,
+		CategoryName: article.CategoryName,
+		LastEditedBy: article.LastEditedBy,
+		CreatedAt:    article.CreatedAt,
+		UpdatedAt:    article.UpdatedAt,
+	}
+	for _, t := range tags {
+		doc.Tags = append(doc.Tags, search.Tag{Slug: t.Slug, Name: t.Name})
+	}
+	return doc, nil
+}
+
+// indexArticle updates the search index after an article is saved. The
+// save has already been committed by then, so a failure is only logged;
+// a reindex brings the index back in line.
+func indexArticle(article *Article) {
+	if search.Default == nil {
+		return
+	}
+	doc, err := searchDocument(article.Slug)
+	if err == nil {
+		err = search.Default.Index(doc)
+	}
+	if err != nil {
+		log.Printf("Error indexing article %d: %v", article.ID, err)
+	}
+}
+
+// ReindexSearch rebuilds the search index from every article.
+func ReindexSearch() (int, error) {
+	if err := search.Default.Reset(); err != nil {
+		return 0, err
+	}
+	articles, err := GetAllArticles()
+	if err != nil {
+		return 0, err
+	}
+	for _, a := range articles {
+		doc, err := searchDocument(a.Slug)
+		if err != nil {
+			return 0, err
+		}
+		if err := search.Default.Index(doc); err != nil {
+			return 0, err
+		}
+	}
+	return len(articles), nil
+}
+
+// EnsureSearchIndex rebuilds the search index when it holds a different
+// number of articles than the database, as a new embedded index does.
+func EnsureSearchIndex() error {
+	indexed, err := search.Default.Count()
+	if err != nil {
+		return err
+	}
+	var total int
+	if err := database.DB.QueryRow(`SELECT COUNT(*) FROM articles WHERE deleted_at IS NULL`).Scan(&total); err != nil {
+		return err
+	}
+	if indexed == total {
+		return nil
+	}
+	_, err = ReindexSearch()
+	return err
+}
+
+// SuggestArticles returns the articles whose title or slug is closest to
+// text by trigram similarity, for "did you mean" hints and 404 pages.
+func SuggestArticles(text string, limit int) ([]Article, error) {
+	rows, err := database.DB.Query(
+		`SELECT id, slug, title, content, COALESCE(category_id, 0), last_edited_by, created_at, updated_at
+		 FROM articles
+		 WHERE deleted_at IS NULL AND (title % $1 OR $1 <% title OR slug % $2)
+		 ORDER BY GREATEST(similarity(title, $1), word_similarity($1, title), similarity(slug, $2)) DESC, title
+		 LIMIT $3`,
+		text, Slugify(text), limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var articles []Article
+	for rows.Next() {
+		var a Article
+		if err := rows.Scan(&a.ID, &a.Slug, &a.Title, &a.Content, &a.CategoryID, &a.LastEditedBy,
+			&a.CreatedAt, &a.UpdatedAt); err != nil {
+			return nil, err
+		}
+		articles = append(articles, a)
+	}
+	return articles, rows.Err()
+}
@@ -0,0 +1,195 @@
+package models
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"silic0n-wiki/database"
+)
+
+// Sources of logged search queries. Suggestion box lookups are no longer
+// logged, and the reports only count searches from the results page.
+const (
+	SearchSourcePage    = "page"
+	SearchSourceSuggest = "suggest"
+)
+
+// maxLoggedQuery caps the stored query length to the column size.
+const maxLoggedQuery = 255
+
+// NormalizeSearchQuery lowercases a query and collapses its whitespace so
+// the same search typed differently is counted once.
+func NormalizeSearchQuery(query string) string {
+	normalized := strings.Join(strings.Fields(strings.ToLower(query)), " ")
+	if len(normalized) > maxLoggedQuery {
+		normalized = strings.ToValidUTF8(normalized[:maxLoggedQuery], "")
+	}
+	return normalized
+}
+
+// LogSearch records a search and returns its id, used to attribute a later
+// click on one of its results.
+func LogSearch(query, source string, resultCount int) (int, error) {
+	var id int
+	err := database.DB.QueryRow(
+		`INSERT INTO search_queries (query, source, result_count) VALUES ($1, $2, $3) RETURNING id`,
+		NormalizeSearchQuery(query), source, resultCount,
+	).Scan(&id)
+	return id, err
+}
+
+// RecordSearchClick marks a logged search as having led to slug. Only the
+// first click counts.
+func RecordSearchClick(id int, slug string) error {
+	_, err := database.DB.Exec(
+		`UPDATE search_queries SET clicked_slug = $1, clicked_at = NOW()
+		 WHERE id = $2 AND clicked_at IS NULL`,
+		slug, id,
+	)
+	return err
+}
+
+type SearchStats struct {
+	Searches    int
+	ZeroResults int
+	Clicked     int
+}
+
+// GetSearchStats counts the searches made from the results page since the
+// given time.
+func GetSearchStats(since time.Time) (*SearchStats, error) {
+	stats := &SearchStats{}
+	err := database.DB.QueryRow(
+		`SELECT COUNT(*),
+		        COUNT(*) FILTER (WHERE result_count = 0),
+		        COUNT(*) FILTER (WHERE clicked_at IS NOT NULL)
+		 FROM search_queries
+		 WHERE created_at >= $1 AND source = $2`,
+		since, SearchSourcePage,
+	).Scan(&stats.Searches, &stats.ZeroResults, &stats.Clicked)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// WantedPage is a page that does not exist but has been searched for
+// without results, linked to, or both.
+type WantedPage struct {
+	Slug         string
+	Title        string
+	Searches     int
+	Links        int
+	LastSearched time.Time
+}
+
+func (p WantedPage) Score() int {
+	return p.Searches + p.Links
+}
+
+// GetWantedPages combines zero-result searches since the given time with
+// links pointing at missing slugs. A search and a link refer to the same
+// page when the query slugifies to the link target. Queries for pages that
+// have since been created are left out.
+func GetWantedPages(since time.Time, limit int) ([]WantedPage, error) {
+	pages := make(map[string]*WantedPage)
+
+	rows, err := database.DB.Query(
+		`SELECT query, COUNT(*), MAX(created_at)
+		 FROM search_queries
+		 WHERE result_count = 0 AND source = $3 AND created_at >= $1
+		 GROUP BY query
+		 ORDER BY COUNT(*) DESC, MAX(created_at) DESC
+		 LIMIT $2`,
+		since, limit, SearchSourcePage,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var slugs []string
+	for rows.Next() {
+		var query string
+		var count int
+		var last time.Time
+		if err := rows.Scan(&query, &count, &last); err != nil {
+			return nil, err
+		}
+		slug := Slugify(query)
+		if slug == "" {
+			continue
+		}
+		p := pages[slug]
+		if p == nil {
+			p = &WantedPage{Slug: slug, Title: query}
+			pages[slug] = p
+			slugs = append(slugs, slug)
+		}
+		p.Searches += count
+		if last.After(p.LastSearched) {
+			p.LastSearched = last
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	existing, err := ExistingSlugs(slugs)
+	if err != nil {
+		return nil, err
+	}
+	for slug := range existing {
+		delete(pages, slug)
+	}
+
+	links, err := database.DB.Query(
+		`SELECT l.target_slug, COUNT(*)
+		 FROM article_links l
+		 JOIN articles src ON src.id = l.article_id AND src.deleted_at IS NULL
+		 WHERE NOT EXISTS (SELECT 1 FROM articles a WHERE a.slug = l.target_slug AND a.deleted_at IS NULL)
+		   AND NOT EXISTS (SELECT 1 FROM slug_aliases s JOIN articles a ON a.id = s.article_id
+		                   WHERE s.slug = l.target_slug AND a.deleted_at IS NULL)
+		 GROUP BY l.target_slug
+		 ORDER BY COUNT(*) DESC, l.target_slug
+		 LIMIT $1`,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer links.Close()
+
+	for links.Next() {
+		var slug string
+		var count int
+		if err := links.Scan(&slug, &count); err != nil {
+			return nil, err
+		}
+		p := pages[slug]
+		if p == nil {
+			p = &WantedPage{Slug: slug, Title: strings.ReplaceAll(slug, "-", " ")}
+			pages[slug] = p
+		}
+		p.Links = count
+	}
+	if err := links.Err(); err != nil {
+		return nil, err
+	}
+
+	wanted := make([]WantedPage, 0, len(pages))
+	for _, p := range pages {
+		wanted = append(wanted, *p)
+	}
+	sort.Slice(wanted, func(i, j int) bool {
+		if wanted[i].Score() != wanted[j].Score() {
+			return wanted[i].Score() > wanted[j].Score()
+		}
+		return wanted[i].Slug < wanted[j].Slug
+	})
+	if len(wanted) > limit {
+		wanted = wanted[:limit]
+	}
+	return wanted, nil
+}
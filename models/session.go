@@ -47,7 +47,18 @@ func DeleteSession(token string) error {
 	return err
 }
 
-func DeleteExpiredSessions() error {
-	_, err := database.DB.Exec(`DELETE FROM sessions WHERE expires_at < NOW()`)
+// DeleteUserSessions logs a user out everywhere.
+func DeleteUserSessions(userID int) error {
+	_, err := database.DB.Exec(`DELETE FROM sessions WHERE user_id = $1`, userID)
 	return err
 }
+
+// DeleteExpiredSessions removes expired sessions and returns how many there
+// were.
+func DeleteExpiredSessions() (int64, error) {
+	result, err := database.DB.Exec(`DELETE FROM sessions WHERE expires_at < NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
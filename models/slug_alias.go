@@ -0,0 +1,144 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"silic0n-wiki/database"
+)
+
+var ErrSlugTaken = errors.New("slug is already used by another article")
+
+type SlugAlias struct {
+	Slug      string
+	ArticleID int
+	CreatedBy string
+	CreatedAt time.Time
+}
+
+// ResolveSlugAlias returns the current slug of the article an old slug
+// points to.
+func ResolveSlugAlias(alias string) (string, error) {
+	var slug string
+	err := database.DB.QueryRow(
+		`SELECT a.slug
+		 FROM slug_aliases sa
+		 JOIN articles a ON a.id = sa.article_id
+		 WHERE sa.slug = $1 AND a.deleted_at IS NULL`,
+		alias,
+	).Scan(&slug)
+	if err != nil {
+		return "", err
+	}
+	return slug, nil
+}
+
+func GetSlugAliasesForArticle(articleID int) ([]SlugAlias, error) {
+	rows, err := database.DB.Query(
+		`SELECT slug, article_id, created_by, created_at
+		 FROM slug_aliases
+		 WHERE article_id = $1
+		 ORDER BY created_at DESC`,
+		articleID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var aliases []SlugAlias
+	for rows.Next() {
+		var a SlugAlias
+		if err := rows.Scan(&a.Slug, &a.ArticleID, &a.CreatedBy, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		aliases = append(aliases, a)
+	}
+	return aliases, rows.Err()
+}
+
+func addSlugAlias(q queryer, slug string, articleID int, createdBy string) error {
+	_, err := q.Exec(
+		`INSERT INTO slug_aliases (slug, article_id, created_by)
+		 VALUES ($1, $2, $3)
+		 ON CONFLICT (slug) DO UPDATE SET article_id = EXCLUDED.article_id,
+		     created_by = EXCLUDED.created_by, created_at = NOW()`,
+		slug, articleID, createdBy,
+	)
+	return err
+}
+
+// claimSlug drops any alias using slug so a live article can take it over.
+func claimSlug(q queryer, slug string) error {
+	_, err := q.Exec(`DELETE FROM slug_aliases WHERE slug = $1`, slug)
+	return err
+}
+
+// MoveArticle gives an article an explicit new slug, optionally keeping the
+// old slug as a redirect. The move is recorded as a revision.
+func MoveArticle(id int, newSlug string, leaveRedirect bool, editor, reason string) (*Article, error) {
+	if newSlug == "" || Slugify(newSlug) != newSlug {
+		return nil, fmt.Errorf("invalid slug %q", newSlug)
+	}
+
+	article := &Article{}
+	moved := false
+	err := database.WithTx(func(tx *sql.Tx) error {
+		current, err := lockArticle(tx, id)
+		if err != nil {
+			return err
+		}
+		if current.Slug == newSlug {
+			*article = *current
+			return nil
+		}
+
+		taken, err := slugTaken(tx, newSlug, id)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return ErrSlugTaken
+		}
+
+		summary := fmt.Sprintf("Moved page from %s to %s", current.Slug, newSlug)
+		if !leaveRedirect {
+			summary += " without leaving a redirect"
+		}
+		if reason != "" {
+			summary += ": " + reason
+		}
+
+		if err := claimSlug(tx, newSlug); err != nil {
+			return err
+		}
+		err = tx.QueryRow(
+			`UPDATE articles
+			 SET slug = $1, last_edited_by = $2, updated_at = NOW()
+			 WHERE id = $3
+			 RETURNING id, slug, title, content, COALESCE(category_id, 0), last_edited_by, created_at, updated_at`,
+			newSlug, editor, id,
+		).Scan(&article.ID, &article.Slug, &article.Title, &article.Content,
+			&article.CategoryID, &article.LastEditedBy, &article.CreatedAt, &article.UpdatedAt)
+		if err != nil {
+			return err
+		}
+		if leaveRedirect {
+			if err := addSlugAlias(tx, current.Slug, id, editor); err != nil {
+				return err
+			}
+		}
+		moved = true
+		_, err = createRevision(tx, id, summary)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if moved {
+		indexArticle(article)
+	}
+	return article, nil
+}
@@ -76,6 +76,7 @@ func GetTagsByCategoryWithCount(categoryID int) ([]TagWithCount, error) {
 		`SELECT t.id, t.slug, t.name, t.category_id, t.created_at, COUNT(at.article_id) as article_count
 		FROM tags t
 		LEFT JOIN article_tags at ON t.id = at.tag_id
+		    AND at.article_id IN (SELECT id FROM articles WHERE deleted_at IS NULL)
 		WHERE t.category_id = $1
 		GROUP BY t.id, t.slug, t.name, t.category_id, t.created_at
 		ORDER BY t.name`,
@@ -147,13 +148,20 @@ func GetOrCreateTag(name string, categoryID int) (*Tag, error) {
 	return tag, nil
 }
 
-func ResolveTagNames(commaSeparated string, categoryID int) ([]int, error) {
-	var tagIDs []int
+func ParseTagNames(commaSeparated string) []string {
+	var names []string
 	for _, raw := range strings.Split(commaSeparated, ",") {
 		name := strings.TrimSpace(raw)
-		if name == "" {
-			continue
+		if name != "" {
+			names = append(names, name)
 		}
+	}
+	return names
+}
+
+func ResolveTagNames(commaSeparated string, categoryID int) ([]int, error) {
+	var tagIDs []int
+	for _, name := range ParseTagNames(commaSeparated) {
 		tag, err := GetOrCreateTag(name, categoryID)
 		if err != nil {
 			return nil, fmt.Errorf("failed to resolve tag %q: %w", name, err)
@@ -168,7 +176,7 @@ func GetArticlesByTag(tagID int) ([]Article, error) {
 		`SELECT a.id, a.slug, a.title, a.content, a.last_edited_by, a.created_at, a.updated_at
 		FROM articles a
 		JOIN article_tags at ON a.id = at.article_id
-		WHERE at.tag_id = $1
+		WHERE at.tag_id = $1 AND a.deleted_at IS NULL
 		ORDER BY a.title`,
 		tagID,
 	)
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"silic0n-wiki/database"
+)
+
+// useTestDB points database.DB at a new schema, migrated to the latest
+// version, in the Postgres database named by TEST_DATABASE_URL (a
+// postgres:// URL). The schema is dropped when the test ends. Without the
+// variable the test is skipped.
+func useTestDB(t *testing.T) {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`); err != nil {
+			t.Errorf("dropping test schema: %v", err)
+		}
+		admin.Close()
+	})
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	query.Set("search_path", schema+",public")
+	u.RawQuery = query.Encode()
+	db, err := sql.Open("postgres", u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prev := database.DB
+	database.DB = db
+	t.Cleanup(func() {
+		database.DB = prev
+		db.Close()
+	})
+
+	if err := database.RunMigrations(os.DirFS("../database/migrations")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testCategoryID returns the id of a category seeded by the migrations.
+func testCategoryID(t *testing.T) int {
+	t.Helper()
+	c, err := GetCategoryBySlug("general")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.ID
+}
@@ -0,0 +1,199 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"silic0n-wiki/database"
+	"silic0n-wiki/search"
+)
+
+// DeletedArticle is an article in the trash.
+type DeletedArticle struct {
+	ID        int
+	Slug      string
+	Title     string
+	DeletedBy string
+	DeletedAt time.Time
+	Reason    string
+}
+
+// Actions recorded in the deletion log.
+const (
+	DeletionActionDelete  = "delete"
+	DeletionActionRestore = "restore"
+	DeletionActionPurge   = "purge"
+)
+
+// DeletionLogEntry records an article being deleted, restored or purged.
+// Entries keep the slug and title so they stay readable after a purge.
+type DeletionLogEntry struct {
+	ID          int
+	ArticleID   int
+	Slug        string
+	Title       string
+	Action      string
+	PerformedBy string
+	Reason      string
+	CreatedAt   time.Time
+}
+
+func logDeletion(q queryer, articleID int, slug, title, action, performedBy, reason string) error {
+	_, err := q.Exec(
+		`INSERT INTO deletion_log (article_id, slug, title, action, performed_by, reason)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		articleID, slug, title, action, performedBy, reason,
+	)
+	return err
+}
+
+// DeleteArticle moves an article to the trash. It disappears from listings,
+// search and links but keeps its revisions and tags until purged. Its slug
+// is freed for a new article.
+func DeleteArticle(id int, deletedBy, reason string) error {
+	err := database.WithTx(func(tx *sql.Tx) error {
+		var slug, title string
+		err := tx.QueryRow(
+			`UPDATE articles SET deleted_at = NOW(), deleted_by = $1, delete_reason = $2
+			 WHERE id = $3 AND deleted_at IS NULL
+			 RETURNING slug, title`,
+			deletedBy, reason, id,
+		).Scan(&slug, &title)
+		if err != nil {
+			return err
+		}
+		return logDeletion(tx, id, slug, title, DeletionActionDelete, deletedBy, reason)
+	})
+	if err != nil {
+		return err
+	}
+
+	if search.Default != nil {
+		if err := search.Default.Delete(id); err != nil {
+			log.Printf("Error removing article %d from search index: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// RestoreArticle takes an article out of the trash. If a live article has
+// taken its slug in the meantime, it is restored under the slug with the
+// lowest free numeric suffix instead, and the log entry says so.
+func RestoreArticle(id int, restoredBy string) (*Article, error) {
+	err := database.WithTx(func(tx *sql.Tx) error {
+		var slug, title string
+		err := tx.QueryRow(
+			`SELECT slug, title FROM articles WHERE id = $1 AND deleted_at IS NOT NULL FOR UPDATE`, id,
+		).Scan(&slug, &title)
+		if err != nil {
+			return err
+		}
+
+		newSlug, err := uniqueSlug(tx, slug, id)
+		if err != nil {
+			return err
+		}
+		reason := ""
+		if newSlug != slug {
+			reason = fmt.Sprintf("restored as %s because %s is used by another article", newSlug, slug)
+		}
+
+		_, err = tx.Exec(
+			`UPDATE articles SET slug = $1, deleted_at = NULL, deleted_by = NULL, delete_reason = ''
+			 WHERE id = $2`,
+			newSlug, id,
+		)
+		if err != nil {
+			return err
+		}
+		return logDeletion(tx, id, newSlug, title, DeletionActionRestore, restoredBy, reason)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	article, err := GetArticleByID(id)
+	if err != nil {
+		return nil, err
+	}
+	indexArticle(article)
+	return article, nil
+}
+
+// PurgeArticle permanently removes an article from the trash. Its tags are
+// removed and its media are kept but no longer attached to any article;
+// revisions, aliases and links go with it. Only the deletion log keeps a
+// record of it.
+func PurgeArticle(id int, purgedBy string) error {
+	return database.WithTx(func(tx *sql.Tx) error {
+		var slug, title string
+		err := tx.QueryRow(
+			`SELECT slug, title FROM articles WHERE id = $1 AND deleted_at IS NOT NULL FOR UPDATE`, id,
+		).Scan(&slug, &title)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM article_tags WHERE article_id = $1`, id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE media SET article_id = NULL WHERE article_id = $1`, id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM articles WHERE id = $1`, id); err != nil {
+			return err
+		}
+		return logDeletion(tx, id, slug, title, DeletionActionPurge, purgedBy, "")
+	})
+}
+
+// GetDeletionLog returns the most recent deletions, restores and purges.
+func GetDeletionLog(limit int) ([]DeletionLogEntry, error) {
+	rows, err := database.DB.Query(
+		`SELECT id, article_id, slug, title, action, performed_by, reason, created_at
+		 FROM deletion_log
+		 ORDER BY created_at DESC, id DESC
+		 LIMIT $1`,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []DeletionLogEntry
+	for rows.Next() {
+		var e DeletionLogEntry
+		if err := rows.Scan(&e.ID, &e.ArticleID, &e.Slug, &e.Title, &e.Action, &e.PerformedBy,
+			&e.Reason, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// GetDeletedArticles lists the trash, most recently deleted first.
+func GetDeletedArticles() ([]DeletedArticle, error) {
+	rows, err := database.DB.Query(
+		`SELECT id, slug, title, COALESCE(deleted_by, ''), deleted_at, delete_reason
+		 FROM articles
+		 WHERE deleted_at IS NOT NULL
+		 ORDER BY deleted_at DESC`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var articles []DeletedArticle
+	for rows.Next() {
+		var a DeletedArticle
+		if err := rows.Scan(&a.ID, &a.Slug, &a.Title, &a.DeletedBy, &a.DeletedAt, &a.Reason); err != nil {
+			return nil, err
+		}
+		articles = append(articles, a)
+	}
+	return articles, rows.Err()
+}
@@ -0,0 +1,109 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRestoreRenamesTakenSlug(t *testing.T) {
+	useTestDB(t)
+	category := testCategoryID(t)
+
+	old, err := CreateArticle("Trash Test", "old", category, nil, "alice", "created")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteArticle(old.ID, "bob", "spam"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The slug of a deleted article is free for a new one.
+	replacement, err := CreateArticle("Trash Test", "new", category, nil, "carol", "created")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replacement.Slug != old.Slug {
+		t.Fatalf("new article got slug %q, want the freed %q", replacement.Slug, old.Slug)
+	}
+
+	restored, err := RestoreArticle(old.ID, "dave")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := old.Slug + "-2"; restored.Slug != want {
+		t.Errorf("restored slug = %q, want %q", restored.Slug, want)
+	}
+	if a, err := GetArticleBySlug(old.Slug); err != nil || a.ID != replacement.ID {
+		t.Errorf("%s no longer leads to the new article: %v", old.Slug, err)
+	}
+
+	entries, err := GetDeletionLog(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d log entries, want 2: %+v", len(entries), entries)
+	}
+	restore, del := entries[0], entries[1]
+	if restore.Action != DeletionActionRestore || restore.PerformedBy != "dave" ||
+		restore.Slug != restored.Slug || !strings.Contains(restore.Reason, old.Slug) {
+		t.Errorf("restore entry = %+v", restore)
+	}
+	if del.Action != DeletionActionDelete || del.PerformedBy != "bob" || del.Reason != "spam" {
+		t.Errorf("delete entry = %+v", del)
+	}
+}
+
+func TestRestoreKeepsFreeSlug(t *testing.T) {
+	useTestDB(t)
+
+	article, err := CreateArticle("Kept Slug", "text", testCategoryID(t), nil, "alice", "created")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteArticle(article.ID, "bob", ""); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := RestoreArticle(article.ID, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Slug != article.Slug {
+		t.Errorf("restored slug = %q, want %q", restored.Slug, article.Slug)
+	}
+	entries, err := GetDeletionLog(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Reason != "" {
+		t.Errorf("restore entry = %+v, want no reason", entries)
+	}
+}
+
+func TestPurgeIsLogged(t *testing.T) {
+	useTestDB(t)
+
+	article, err := CreateArticle("Purged", "text", testCategoryID(t), nil, "alice", "created")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := PurgeArticle(article.ID, "bob"); err == nil {
+		t.Error("a live article was purged")
+	}
+	if err := DeleteArticle(article.ID, "bob", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := PurgeArticle(article.ID, "carol"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetArticleByID(article.ID); err == nil {
+		t.Error("purged article still exists")
+	}
+	entries, err := GetDeletionLog(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Action != DeletionActionPurge || entries[0].Title != "Purged" {
+		t.Errorf("purge entry = %+v", entries)
+	}
+}
@@ -1,6 +1,10 @@
 package models
 
 import (
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
 	"time"
 
 	"silic0n-wiki/database"
@@ -11,30 +15,51 @@ type User struct {
 	Username     string
 	Email        string
 	PasswordHash string
+	Role         Role
+	Disabled     bool
 	CreatedAt    time.Time
 }
 
-func CreateUser(username, email, passwordHash string) (*User, error) {
-	user := &User{}
-	err := database.DB.QueryRow(
-		`INSERT INTO users (username, email, password_hash)
-		 VALUES ($1, $2, $3)
-		 RETURNING id, username, email, password_hash, created_at`,
-		username, email, passwordHash,
-	).Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.CreatedAt)
-	if err != nil {
-		return nil, err
+var usernameRegex = regexp.MustCompile(`^[a-zA-Z0-9_]+$`)
+
+func ValidateUsername(username string) error {
+	if len(username) < 3 || len(username) > 50 {
+		return errors.New("Username must be between 3 and 50 characters")
 	}
-	return user, nil
+	if !usernameRegex.MatchString(username) {
+		return errors.New("Username may only contain letters, numbers, and underscores")
+	}
+	return nil
+}
+
+func ValidateEmail(email string) error {
+	if !strings.Contains(email, "@") || !strings.Contains(email, ".") {
+		return errors.New("Please enter a valid email address")
+	}
+	return nil
+}
+
+func ValidatePassword(password string) error {
+	if len(password) < 8 {
+		return errors.New("Password must be at least 8 characters")
+	}
+	return nil
+}
+
+// CreateUser registers a new editor. Nobody is made an admin by signing
+// up; the first admin is created with the "user create -role admin"
+// command.
+func CreateUser(username, email, passwordHash string) (*User, error) {
+	return CreateUserWithRole(username, email, passwordHash, RoleEditor)
 }
 
 func GetUserByUsername(username string) (*User, error) {
 	user := &User{}
 	err := database.DB.QueryRow(
-		`SELECT id, username, email, password_hash, created_at
+		`SELECT id, username, email, password_hash, role, disabled_at IS NOT NULL, created_at
 		 FROM users WHERE username = $1`,
 		username,
-	).Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.CreatedAt)
+	).Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.Role, &user.Disabled, &user.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -44,10 +69,10 @@ func GetUserByUsername(username string) (*User, error) {
 func GetUserByEmail(email string) (*User, error) {
 	user := &User{}
 	err := database.DB.QueryRow(
-		`SELECT id, username, email, password_hash, created_at
+		`SELECT id, username, email, password_hash, role, disabled_at IS NOT NULL, created_at
 		 FROM users WHERE email = $1`,
 		email,
-	).Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.CreatedAt)
+	).Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.Role, &user.Disabled, &user.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -57,12 +82,85 @@ func GetUserByEmail(email string) (*User, error) {
 func GetUserByID(id int) (*User, error) {
 	user := &User{}
 	err := database.DB.QueryRow(
-		`SELECT id, username, email, password_hash, created_at
+		`SELECT id, username, email, password_hash, role, disabled_at IS NOT NULL, created_at
 		 FROM users WHERE id = $1`,
 		id,
-	).Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.CreatedAt)
+	).Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.Role, &user.Disabled, &user.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
 	return user, nil
 }
+
+func CreateUserWithRole(username, email, passwordHash string, role Role) (*User, error) {
+	user := &User{}
+	err := database.DB.QueryRow(
+		`INSERT INTO users (username, email, password_hash, role)
+		 VALUES ($1, $2, $3, $4)
+		 RETURNING id, username, email, password_hash, role, disabled_at IS NOT NULL, created_at`,
+		username, email, passwordHash, role,
+	).Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.Role, &user.Disabled, &user.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// GetAllUsers returns every account, oldest first.
+func GetAllUsers() ([]User, error) {
+	rows, err := database.DB.Query(
+		`SELECT id, username, email, password_hash, role, disabled_at IS NOT NULL, created_at
+		 FROM users ORDER BY created_at, id`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.PasswordHash, &u.Role, &u.Disabled, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+func SetUserRole(id int, role Role) error {
+	return updateUser(`UPDATE users SET role = $1 WHERE id = $2`, role, id)
+}
+
+func SetUserPassword(id int, passwordHash string) error {
+	return updateUser(`UPDATE users SET password_hash = $1 WHERE id = $2`, passwordHash, id)
+}
+
+// SetUserDisabled disables or re-enables an account. Disabling also ends
+// the user's sessions.
+func SetUserDisabled(id int, disabled bool) error {
+	if !disabled {
+		return updateUser(`UPDATE users SET disabled_at = NULL WHERE id = $1`, id)
+	}
+	if err := updateUser(`UPDATE users SET disabled_at = COALESCE(disabled_at, NOW()) WHERE id = $1`, id); err != nil {
+		return err
+	}
+	return DeleteUserSessions(id)
+}
+
+// updateUser runs an UPDATE on a single user, returning sql.ErrNoRows if
+// the user does not exist.
+func updateUser(query string, args ...any) error {
+	result, err := database.DB.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
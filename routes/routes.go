@@ -1,30 +1,44 @@
 package routes
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"silic0n-wiki/assets"
 	"silic0n-wiki/config"
 	"silic0n-wiki/handlers"
 	"silic0n-wiki/middleware"
+	"silic0n-wiki/models"
 )
 
 func StartRouter() {
 	mux := http.NewServeMux()
 
-	fileserver := http.FileServer(http.Dir("./static/"))
+	fileserver := http.FileServer(http.FS(assets.Static()))
 	mux.Handle("GET /static/", http.StripPrefix("/static", fileserver))
 
 	// Public routes
 	mux.HandleFunc("GET /", handlers.Index)
 	mux.HandleFunc("GET /wiki/{slug}", handlers.Article)
+	mux.HandleFunc("GET /wiki/{slug}/history", handlers.ArticleHistory)
+	mux.HandleFunc("GET /wiki/{slug}/diff", handlers.ArticleDiff)
+	mux.HandleFunc("GET /wiki/{slug}/backlinks", handlers.ArticleBacklinks)
 	mux.HandleFunc("GET /articles/recent", handlers.RecentArticles)
 	mux.HandleFunc("GET /categories", handlers.Categories)
 	mux.HandleFunc("GET /categories/{slug}", handlers.CategoryArticles)
 	mux.HandleFunc("GET /categories/{category}/tags/{tag}", handlers.TagArticles)
+	mux.HandleFunc("GET /search", handlers.SearchPage)
 	mux.HandleFunc("GET /api/search", handlers.Search)
+	mux.HandleFunc("GET /search/click/{id}", handlers.SearchClick)
+	mux.HandleFunc("GET /media", handlers.MediaLibrary)
 	mux.HandleFunc("GET /media/{filename}", handlers.ServeMedia)
+	mux.HandleFunc("GET /media/thumb/{w}/{filename}", handlers.ServeThumbnail)
 
 	// Auth routes
 	mux.HandleFunc("GET /register", handlers.RegisterPage)
@@ -33,19 +47,47 @@ func StartRouter() {
 	mux.HandleFunc("POST /login", handlers.LoginSubmit)
 	mux.HandleFunc("POST /logout", middleware.RequireCSRF(handlers.Logout))
 
-	// Protected routes (require auth + CSRF on POST)
-	mux.HandleFunc("GET /wiki/new", middleware.RequireAuth(handlers.CreateArticlePage))
-	mux.HandleFunc("POST /wiki/new", middleware.RequireAuth(middleware.RequireCSRF(handlers.CreateArticleSubmit)))
-	mux.HandleFunc("GET /wiki/{slug}/edit", middleware.RequireAuth(handlers.EditArticlePage))
-	mux.HandleFunc("POST /wiki/{slug}/edit", middleware.RequireAuth(middleware.RequireCSRF(handlers.EditArticleSubmit)))
-	mux.HandleFunc("POST /api/media/upload", middleware.RequireAuth(middleware.RequireCSRF(handlers.MediaUpload)))
+	// Protected routes (require a permission + CSRF on POST)
+	mux.HandleFunc("GET /wiki/new", middleware.RequirePermission(models.PermArticleEdit, handlers.CreateArticlePage))
+	mux.HandleFunc("POST /wiki/new", middleware.RequirePermission(models.PermArticleEdit, middleware.RequireCSRF(handlers.CreateArticleSubmit)))
+	mux.HandleFunc("GET /wiki/{slug}/edit", middleware.RequirePermission(models.PermArticleEdit, handlers.EditArticlePage))
+	mux.HandleFunc("POST /wiki/{slug}/edit", middleware.RequirePermission(models.PermArticleEdit, middleware.RequireCSRF(handlers.EditArticleSubmit)))
+	mux.HandleFunc("GET /wiki/{slug}/move", middleware.RequirePermission(models.PermArticleEdit, handlers.MoveArticlePage))
+	mux.HandleFunc("POST /wiki/{slug}/move", middleware.RequirePermission(models.PermArticleEdit, middleware.RequireCSRF(handlers.MoveArticleSubmit)))
+	mux.HandleFunc("POST /wiki/{slug}/revert/{revisionID}", middleware.RequirePermission(models.PermArticleEdit, middleware.RequireCSRF(handlers.RevertArticle)))
+	mux.HandleFunc("GET /wiki/{slug}/protect", middleware.RequirePermission(models.PermArticleProtect, handlers.ProtectArticlePage))
+	mux.HandleFunc("POST /wiki/{slug}/protect", middleware.RequirePermission(models.PermArticleProtect, middleware.RequireCSRF(handlers.ProtectArticleSubmit)))
+	mux.HandleFunc("GET /wiki/{slug}/delete", middleware.RequirePermission(models.PermArticleDelete, handlers.DeleteArticlePage))
+	mux.HandleFunc("POST /wiki/{slug}/delete", middleware.RequirePermission(models.PermArticleDelete, middleware.RequireCSRF(handlers.DeleteArticleSubmit)))
+	mux.HandleFunc("POST /wiki/{slug}/rollback", middleware.RequirePermission(models.PermArticleEdit, middleware.RequireCSRF(handlers.RollbackArticle)))
+	mux.HandleFunc("POST /api/media/upload", middleware.RequirePermission(models.PermMediaUpload, middleware.RequireCSRF(handlers.MediaUpload)))
+
+	// Admin routes
+	mux.HandleFunc("GET /admin/wanted", middleware.RequirePermission(models.PermReportsView, handlers.WantedPages))
+	mux.HandleFunc("GET /admin/trash", middleware.RequirePermission(models.PermArticleDelete, handlers.Trash))
+	mux.HandleFunc("POST /admin/trash/{id}/restore", middleware.RequirePermission(models.PermArticleDelete, middleware.RequireCSRF(handlers.RestoreArticle)))
+	mux.HandleFunc("POST /admin/trash/{id}/purge", middleware.RequirePermission(models.PermArticleDelete, middleware.RequireCSRF(handlers.PurgeArticle)))
+	mux.HandleFunc("GET /admin/users", middleware.RequirePermission(models.PermUserManage, handlers.AdminUsers))
+	mux.HandleFunc("POST /admin/users/{id}/role", middleware.RequirePermission(models.PermUserManage, middleware.RequireCSRF(handlers.AdminSetUserRole)))
 
 	// Wrap entire mux with session loading middleware
 	wrappedMux := middleware.LoadSession(mux)
 
 	addr := fmt.Sprintf(":%d", config.AppConfig.Server.Port)
-	err := http.ListenAndServe(addr, wrappedMux)
-	if err != nil {
+	srv := &http.Server{Addr: addr, Handler: wrappedMux}
+
+	// Stop accepting requests on SIGINT or SIGTERM and return once the
+	// ones in flight are done, so the caller can flush the search index.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		log.Println("Shutting down")
+		srv.Shutdown(context.Background())
+	}()
+
+	err := srv.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatal(err)
 	}
 }
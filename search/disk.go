@@ -0,0 +1,577 @@
+package search
+
+import (
+	"encoding/gob"
+	"errors"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DiskIndex is an in-memory inverted index persisted to a single file. The
+// documents are stored on disk and the postings are rebuilt from them when
+// the index is opened, so the file format only changes with Document.
+//
+// Changes are written back at most once every saveDelay, and by Flush and
+// Close, so a burst of edits or a full reindex rewrites the file once. A
+// crash loses at most the last few seconds of changes, which the next
+// reindex restores. The file is only read when the index is opened, so a
+// server using it must be stopped while another process reindexes.
+type DiskIndex struct {
+	mu       sync.RWMutex
+	path     string
+	docs     map[int]*indexedDoc
+	postings map[string]map[int]struct{}
+
+	// dirty and saveTimer are guarded by mu. saveMu serialises writes of
+	// the file and is taken before mu.
+	dirty     bool
+	saveTimer *time.Timer
+	saveMu    sync.Mutex
+}
+
+// saveDelay is how long a change waits before the file is rewritten.
+const saveDelay = 2 * time.Second
+
+type indexedDoc struct {
+	Document
+	title []word
+	body  []word
+	freq  map[string]termFreq
+}
+
+type termFreq struct {
+	title int
+	body  int
+}
+
+// diskFile is the on-disk representation of a DiskIndex.
+type diskFile struct {
+	Version int
+	Docs    []Document
+}
+
+const diskFileVersion = 1
+
+const (
+	titleWeight      = 3.0
+	fuzzyThreshold   = 0.3
+	fuzzyWordMinimum = 0.6
+	snippetBefore    = 8
+	snippetWords     = 35
+	facetTagLimit    = 25
+)
+
+// OpenDiskIndex loads the index stored at path, or starts an empty one if
+// the file does not exist yet.
+func OpenDiskIndex(path string) (*DiskIndex, error) {
+	if path == "" {
+		path = "search.idx"
+	}
+	idx := &DiskIndex{
+		path:     path,
+		docs:     make(map[int]*indexedDoc),
+		postings: make(map[string]map[int]struct{}),
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var file diskFile
+	if err := gob.NewDecoder(f).Decode(&file); err != nil {
+		return nil, err
+	}
+	if file.Version != diskFileVersion {
+		// An index from another version is simply rebuilt by the next reindex.
+		return idx, nil
+	}
+	for _, doc := range file.Docs {
+		idx.add(doc)
+	}
+	return idx, nil
+}
+
+func (d *DiskIndex) Index(doc Document) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.remove(doc.ID)
+	d.add(doc)
+	d.scheduleSave()
+	return nil
+}
+
+func (d *DiskIndex) Delete(id int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.docs[id]; !ok {
+		return nil
+	}
+	d.remove(id)
+	d.scheduleSave()
+	return nil
+}
+
+func (d *DiskIndex) Reset() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.docs = make(map[int]*indexedDoc)
+	d.postings = make(map[string]map[int]struct{})
+	d.scheduleSave()
+	return nil
+}
+
+// Close writes any pending changes.
+func (d *DiskIndex) Close() error {
+	return d.Flush()
+}
+
+func (d *DiskIndex) Count() (int, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return len(d.docs), nil
+}
+
+func (d *DiskIndex) add(doc Document) {
+	entry := &indexedDoc{
+		Document: doc,
+		title:    splitWords(doc.Title),
+		body:     splitWords(doc.Content),
+		freq:     make(map[string]termFreq),
+	}
+	for _, w := range entry.title {
+		if w.term != "" {
+			tf := entry.freq[w.term]
+			tf.title++
+			entry.freq[w.term] = tf
+		}
+	}
+	for _, w := range entry.body {
+		if w.term != "" {
+			tf := entry.freq[w.term]
+			tf.body++
+			entry.freq[w.term] = tf
+		}
+	}
+
+	d.docs[doc.ID] = entry
+	for term := range entry.freq {
+		if d.postings[term] == nil {
+			d.postings[term] = make(map[int]struct{})
+		}
+		d.postings[term][doc.ID] = struct{}{}
+	}
+}
+
+func (d *DiskIndex) remove(id int) {
+	entry, ok := d.docs[id]
+	if !ok {
+		return
+	}
+	for term := range entry.freq {
+		delete(d.postings[term], id)
+		if len(d.postings[term]) == 0 {
+			delete(d.postings, term)
+		}
+	}
+	delete(d.docs, id)
+}
+
+// scheduleSave marks the index as changed and starts the save timer if it
+// is not already running. d.mu must be held.
+func (d *DiskIndex) scheduleSave() {
+	d.dirty = true
+	if d.saveTimer != nil {
+		return
+	}
+	d.saveTimer = time.AfterFunc(saveDelay, func() {
+		if err := d.Flush(); err != nil {
+			log.Printf("Error saving search index: %v", err)
+		}
+	})
+}
+
+// Flush writes pending changes to disk. The documents are copied under the
+// lock and encoded outside it, so queries and edits are not held up by the
+// write.
+func (d *DiskIndex) Flush() error {
+	d.saveMu.Lock()
+	defer d.saveMu.Unlock()
+
+	d.mu.Lock()
+	if d.saveTimer != nil {
+		d.saveTimer.Stop()
+		d.saveTimer = nil
+	}
+	if !d.dirty {
+		d.mu.Unlock()
+		return nil
+	}
+	file := diskFile{Version: diskFileVersion, Docs: make([]Document, 0, len(d.docs))}
+	for _, entry := range d.docs {
+		file.Docs = append(file.Docs, entry.Document)
+	}
+	d.dirty = false
+	d.mu.Unlock()
+
+	if err := d.save(&file); err != nil {
+		d.mu.Lock()
+		d.scheduleSave()
+		d.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// save writes file to a temporary file and renames it into place so a
+// crash never leaves a truncated index behind.
+func (d *DiskIndex) save(file *diskFile) error {
+	if dir := filepath.Dir(d.path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(d.path), filepath.Base(d.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(tmp).Encode(file); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), d.path)
+}
+
+// scoredDoc is a document matching a query, before filters are applied.
+type scoredDoc struct {
+	doc        *indexedDoc
+	score      float64
+	similarity float64
+	exact      bool
+}
+
+func (d *DiskIndex) Query(q Query) (*Results, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	parsed := parseQuery(q.Text)
+	matches := d.match(parsed, q.Text)
+
+	results := &Results{}
+	var hits []scoredDoc
+	for _, m := range matches {
+		if m.doc.inCategory(q.Category) && m.doc.hasTag(q.Tag) {
+			hits = append(hits, m)
+		}
+	}
+	results.Total = len(hits)
+
+	switch q.Sort {
+	case SortTitle:
+		sort.Slice(hits, func(i, j int) bool {
+			return strings.ToLower(hits[i].doc.Title) < strings.ToLower(hits[j].doc.Title)
+		})
+	case SortUpdated:
+		sort.Slice(hits, func(i, j int) bool {
+			return hits[i].doc.UpdatedAt.After(hits[j].doc.UpdatedAt)
+		})
+	default:
+		sort.Slice(hits, func(i, j int) bool {
+			a, b := hits[i], hits[j]
+			if a.score != b.score {
+				return a.score > b.score
+			}
+			if a.similarity != b.similarity {
+				return a.similarity > b.similarity
+			}
+			return strings.ToLower(a.doc.Title) < strings.ToLower(b.doc.Title)
+		})
+	}
+
+	start := min(q.Offset, len(hits))
+	end := len(hits)
+	if q.Limit > 0 {
+		end = min(start+q.Limit, len(hits))
+	}
+	terms := parsed.positiveTerms()
+	for _, m := range hits[start:end] {
+		results.Hits = append(results.Hits, Hit{
+			ID:           m.doc.ID,
+			Slug:         m.doc.Slug,
+			Title:        m.doc.Title,
+			CategorySlug: m.doc.CategorySlug,
+			CategoryName: m.doc.CategoryName,
+			LastEditedBy: m.doc.LastEditedBy,
+			UpdatedAt:    m.doc.UpdatedAt,
+			Snippet:      snippet(m.doc.Content, m.doc.body, terms),
+			Score:        m.score,
+			Exact:        m.exact,
+		})
+	}
+
+	categories := make(map[string]*Facet)
+	tags := make(map[string]*Facet)
+	for _, m := range matches {
+		if m.doc.CategorySlug != "" && m.doc.hasTag(q.Tag) {
+			f := categories[m.doc.CategorySlug]
+			if f == nil {
+				f = &Facet{Slug: m.doc.CategorySlug, Name: m.doc.CategoryName}
+				categories[f.Slug] = f
+			}
+			f.Count++
+		}
+		if m.doc.inCategory(q.Category) {
+			seen := make(map[string]bool)
+			for _, t := range m.doc.Tags {
+				if seen[t.Slug] {
+					continue
+				}
+				seen[t.Slug] = true
+				f := tags[t.Slug]
+				if f == nil {
+					f = &Facet{Slug: t.Slug, Name: t.Name}
+					tags[f.Slug] = f
+				}
+				f.Count++
+			}
+		}
+	}
+	results.Categories = sortFacets(categories, 0)
+	results.Tags = sortFacets(tags, facetTagLimit)
+
+	return results, nil
+}
+
+// match returns every document matching the parsed query, scored. Like
+// the Postgres backend, titles and slugs containing the raw query match as
+// well, and titles similar to it match approximately.
+func (d *DiskIndex) match(parsed parsedQuery, raw string) []scoredDoc {
+	found := make(map[int]*scoredDoc)
+
+	if len(parsed.clauses) > 0 {
+		for id := range d.candidates(parsed.clauses[0]) {
+			doc := d.docs[id]
+			if !parsed.matches(doc) {
+				continue
+			}
+			found[id] = &scoredDoc{doc: doc, score: d.score(doc, parsed), exact: true}
+		}
+	}
+
+	needle := strings.ToLower(strings.TrimSpace(raw))
+	if needle == "" {
+		return collectMatches(found)
+	}
+	for id, doc := range d.docs {
+		if _, ok := found[id]; ok {
+			continue
+		}
+		if strings.Contains(strings.ToLower(doc.Title), needle) ||
+			strings.Contains(strings.ToLower(doc.Slug), needle) {
+			found[id] = &scoredDoc{doc: doc}
+			continue
+		}
+		if sim := titleSimilarity(needle, doc.Title); sim > 0 {
+			found[id] = &scoredDoc{doc: doc, similarity: sim}
+		}
+	}
+	for _, m := range found {
+		if m.similarity == 0 {
+			m.similarity = titleSimilarity(needle, m.doc.Title)
+		}
+	}
+	return collectMatches(found)
+}
+
+func collectMatches(found map[int]*scoredDoc) []scoredDoc {
+	matches := make([]scoredDoc, 0, len(found))
+	for _, m := range found {
+		matches = append(matches, *m)
+	}
+	return matches
+}
+
+// candidates returns the documents containing every term of at least one
+// alternative of the clause.
+func (d *DiskIndex) candidates(c clause) map[int]struct{} {
+	out := make(map[int]struct{})
+	for _, alt := range c.alternatives {
+		if len(alt) == 0 {
+			continue
+		}
+		for id := range d.postings[alt[0]] {
+			ok := true
+			for _, term := range alt[1:] {
+				if _, has := d.postings[term][id]; !has {
+					ok = false
+					break
+				}
+			}
+			if ok {
+				out[id] = struct{}{}
+			}
+		}
+	}
+	return out
+}
+
+// score sums tf-idf over the query terms, counting title occurrences more
+// than body ones and damping long bodies.
+func (d *DiskIndex) score(doc *indexedDoc, parsed parsedQuery) float64 {
+	var score float64
+	n := float64(len(d.docs))
+	for _, term := range parsed.positiveTerms() {
+		tf, ok := doc.freq[term]
+		if !ok {
+			continue
+		}
+		idf := math.Log(1 + n/float64(len(d.postings[term])))
+		weight := titleWeight * float64(tf.title)
+		if tf.body > 0 {
+			weight += 1 + math.Log(float64(tf.body))
+		}
+		score += idf * weight
+	}
+	return score / (1 + math.Log(1+float64(len(doc.body))/100))
+}
+
+func (doc *indexedDoc) inCategory(slug string) bool {
+	return slug == "" || doc.CategorySlug == slug
+}
+
+func (doc *indexedDoc) hasTag(slug string) bool {
+	if slug == "" {
+		return true
+	}
+	for _, t := range doc.Tags {
+		if t.Slug == slug {
+			return true
+		}
+	}
+	return false
+}
+
+func sortFacets(m map[string]*Facet, limit int) []Facet {
+	facets := make([]Facet, 0, len(m))
+	for _, f := range m {
+		facets = append(facets, *f)
+	}
+	sort.Slice(facets, func(i, j int) bool {
+		if facets[i].Count != facets[j].Count {
+			return facets[i].Count > facets[j].Count
+		}
+		return facets[i].Name < facets[j].Name
+	})
+	if limit > 0 && len(facets) > limit {
+		facets = facets[:limit]
+	}
+	return facets
+}
+
+// snippet returns about snippetWords words of content around the first
+// occurrence of a query term, with occurrences wrapped in the highlight
+// markers. Without any occurrence it returns the start of the content.
+func snippet(content string, words []word, terms []string) string {
+	if len(words) == 0 {
+		return ""
+	}
+	want := make(map[string]bool, len(terms))
+	for _, t := range terms {
+		want[t] = true
+	}
+
+	first := 0
+	for i, w := range words {
+		if want[w.term] {
+			first = max(i-snippetBefore, 0)
+			break
+		}
+	}
+	last := min(first+snippetWords, len(words))
+
+	var b strings.Builder
+	if first > 0 {
+		b.WriteString("… ")
+	}
+	for i := first; i < last; i++ {
+		w := words[i]
+		if i > first {
+			b.WriteString(content[words[i-1].end:w.start])
+		}
+		if want[w.term] {
+			b.WriteString(HighlightStart + content[w.start:w.end] + HighlightStop)
+		} else {
+			b.WriteString(content[w.start:w.end])
+		}
+	}
+	if last < len(words) {
+		b.WriteString(" …")
+	}
+	return b.String()
+}
+
+// titleSimilarity compares a query with a title the way pg_trgm's
+// similarity and word_similarity do, returning 0 below their default
+// thresholds.
+func titleSimilarity(query, title string) float64 {
+	title = strings.ToLower(title)
+	best := 0.0
+	if sim := trigramSimilarity(query, title); sim >= fuzzyThreshold {
+		best = sim
+	}
+
+	qWords := strings.Fields(query)
+	tWords := strings.Fields(title)
+	for i := 0; i+len(qWords) <= len(tWords); i++ {
+		window := strings.Join(tWords[i:i+len(qWords)], " ")
+		if sim := trigramSimilarity(query, window); sim >= fuzzyWordMinimum && sim > best {
+			best = sim
+		}
+	}
+	return best
+}
+
+func trigramSimilarity(a, b string) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0
+	}
+	shared := 0
+	for t := range ta {
+		if tb[t] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(ta)+len(tb)-shared)
+}
+
+// trigrams returns the set of three-rune sequences of each word, padded
+// with two spaces in front and one behind as pg_trgm does.
+func trigrams(s string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range splitWords(s) {
+		r := []rune("  " + strings.ToLower(w.raw) + " ")
+		for i := 0; i+3 <= len(r); i++ {
+			set[string(r[i:i+3])] = true
+		}
+	}
+	return set
+}
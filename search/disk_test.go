@@ -0,0 +1,237 @@
+package search
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testDocs = []Document{
+	{ID: 1, Slug: "postgres", Title: "Postgres", Content: "Postgres is a relational database with full text search.",
+		CategorySlug: "databases", CategoryName: "Databases", Tags: []Tag{{Slug: "sql", Name: "SQL"}},
+		UpdatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+	{ID: 2, Slug: "sqlite", Title: "SQLite", Content: "SQLite is an embedded database. It has full text search through FTS5.",
+		CategorySlug: "databases", CategoryName: "Databases", Tags: []Tag{{Slug: "sql", Name: "SQL"}, {Slug: "embedded", Name: "Embedded"}},
+		UpdatedAt: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+	{ID: 3, Slug: "go", Title: "Go", Content: "Go is a programming language. Text processing in Go is fast.",
+		CategorySlug: "languages", CategoryName: "Languages",
+		UpdatedAt: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+	{ID: 4, Slug: "search-engines", Title: "Search engines", Content: "A search engine indexes documents. Databases can search text too.",
+		UpdatedAt: time.Date(2023, 12, 1, 0, 0, 0, 0, time.UTC)},
+}
+
+func newTestIndex(t *testing.T) *DiskIndex {
+	t.Helper()
+	idx, err := OpenDiskIndex(filepath.Join(t.TempDir(), "search.idx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { idx.Close() })
+	for _, doc := range testDocs {
+		if err := idx.Index(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return idx
+}
+
+func hitSlugs(r *Results) []string {
+	var slugs []string
+	for _, h := range r.Hits {
+		slugs = append(slugs, h.Slug)
+	}
+	return slugs
+}
+
+func TestDiskIndexQuery(t *testing.T) {
+	idx := newTestIndex(t)
+	tests := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{"title match ranks first", Query{Text: "postgres"}, []string{"postgres"}},
+		{"plural finds singular", Query{Text: "databases", Sort: SortTitle}, []string{"postgres", "search-engines", "sqlite"}},
+		{"title weighs more than body", Query{Text: "search"}, []string{"search-engines", "postgres", "sqlite"}},
+		{"all words required", Query{Text: "embedded database", Sort: SortTitle}, []string{"sqlite"}},
+		{"phrase", Query{Text: `"full text search"`, Sort: SortTitle}, []string{"postgres", "sqlite"}},
+		{"phrase order matters", Query{Text: `"search full text"`}, nil},
+		{"or", Query{Text: "fts5 or language", Sort: SortTitle}, []string{"go", "sqlite"}},
+		{"excluded", Query{Text: "database -embedded", Sort: SortTitle}, []string{"postgres", "search-engines"}},
+		{"category filter", Query{Text: "text", Category: "databases", Sort: SortTitle}, []string{"postgres", "sqlite"}},
+		{"tag filter", Query{Text: "database", Tag: "embedded"}, []string{"sqlite"}},
+		{"sort by update", Query{Text: "text", Sort: SortUpdated}, []string{"sqlite", "go", "postgres", "search-engines"}},
+		{"limit and offset", Query{Text: "text", Sort: SortTitle, Limit: 2, Offset: 1}, []string{"postgres", "search-engines"}},
+		{"title substring", Query{Text: "sqli"}, []string{"sqlite"}},
+		{"misspelt title", Query{Text: "postgress"}, []string{"postgres"}},
+		{"no match", Query{Text: "kubernetes"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := idx.Query(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if slugs := hitSlugs(got); !reflect.DeepEqual(slugs, tt.want) {
+				t.Errorf("Query(%+v) = %q, want %q", tt.query, slugs, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiskIndexExact(t *testing.T) {
+	idx := newTestIndex(t)
+	got, err := idx.Query(Query{Text: "postgress"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Hits) != 1 || got.Hits[0].Exact {
+		t.Errorf("a fuzzy title match should not be exact: %+v", got.Hits)
+	}
+	got, err = idx.Query(Query{Text: "postgres"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Hits) != 1 || !got.Hits[0].Exact {
+		t.Errorf("a full-text match should be exact: %+v", got.Hits)
+	}
+}
+
+func TestDiskIndexFacets(t *testing.T) {
+	idx := newTestIndex(t)
+	got, err := idx.Query(Query{Text: "text", Category: "databases"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Categories ignore the category filter; tags respect it.
+	wantCategories := []Facet{
+		{Slug: "databases", Name: "Databases", Count: 2},
+		{Slug: "languages", Name: "Languages", Count: 1},
+	}
+	wantTags := []Facet{
+		{Slug: "sql", Name: "SQL", Count: 2},
+		{Slug: "embedded", Name: "Embedded", Count: 1},
+	}
+	if !reflect.DeepEqual(got.Categories, wantCategories) {
+		t.Errorf("Categories = %+v, want %+v", got.Categories, wantCategories)
+	}
+	if !reflect.DeepEqual(got.Tags, wantTags) {
+		t.Errorf("Tags = %+v, want %+v", got.Tags, wantTags)
+	}
+	if got.Total != 2 {
+		t.Errorf("Total = %d, want 2", got.Total)
+	}
+}
+
+func TestDiskIndexUpdateAndDelete(t *testing.T) {
+	idx := newTestIndex(t)
+
+	doc := testDocs[2]
+	doc.Content = "Go has goroutines."
+	if err := idx.Index(doc); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := idx.Query(Query{Text: "processing"}); len(got.Hits) != 0 {
+		t.Errorf("old content still matches: %q", hitSlugs(got))
+	}
+	if got, _ := idx.Query(Query{Text: "goroutines"}); !reflect.DeepEqual(hitSlugs(got), []string{"go"}) {
+		t.Errorf("new content does not match: %q", hitSlugs(got))
+	}
+
+	if err := idx.Delete(doc.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := idx.Query(Query{Text: "goroutines"}); len(got.Hits) != 0 {
+		t.Errorf("deleted document still matches: %q", hitSlugs(got))
+	}
+	if _, ok := idx.postings["goroutine"]; ok {
+		t.Error("postings of a deleted document were kept")
+	}
+	if n, _ := idx.Count(); n != len(testDocs)-1 {
+		t.Errorf("Count = %d, want %d", n, len(testDocs)-1)
+	}
+}
+
+func TestDiskIndexPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "search.idx")
+	idx, err := OpenDiskIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, doc := range testDocs {
+		idx.Index(doc)
+	}
+	idx.Delete(3)
+	if err := idx.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenDiskIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if n, _ := reopened.Count(); n != len(testDocs)-1 {
+		t.Errorf("Count after reopening = %d, want %d", n, len(testDocs)-1)
+	}
+	got, err := reopened.Query(Query{Text: "database", Sort: SortTitle})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"postgres", "search-engines", "sqlite"}; !reflect.DeepEqual(hitSlugs(got), want) {
+		t.Errorf("Query after reopening = %q, want %q", hitSlugs(got), want)
+	}
+}
+
+func TestDiskIndexFlush(t *testing.T) {
+	idx := newTestIndex(t)
+	idx.mu.RLock()
+	pending := idx.dirty && idx.saveTimer != nil
+	idx.mu.RUnlock()
+	if !pending {
+		t.Fatal("indexing did not schedule a save")
+	}
+
+	if err := idx.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	if idx.dirty || idx.saveTimer != nil {
+		t.Error("Flush left a save pending")
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	content := strings.Repeat("filler ", 20) + "the database is here"
+	got := snippet(content, splitWords(content), []string{"database"})
+	want := "… " + strings.Repeat("filler ", 7) + "the " + HighlightStart + "database" + HighlightStop + " is here"
+	if got != want {
+		t.Errorf("snippet =\n%q\nwant\n%q", got, want)
+	}
+
+	short := "no match here"
+	if got := snippet(short, splitWords(short), []string{"database"}); got != short {
+		t.Errorf("snippet without a match = %q, want %q", got, short)
+	}
+}
+
+func TestTitleSimilarity(t *testing.T) {
+	tests := []struct {
+		query, title string
+		match        bool
+	}{
+		{"postgres", "Postgres", true},
+		{"postgress", "Postgres", true},
+		{"databse", "Database design", true},
+		{"kubernetes", "Postgres", false},
+		{"go", "Search engines", false},
+	}
+	for _, tt := range tests {
+		if got := titleSimilarity(tt.query, tt.title); (got > 0) != tt.match {
+			t.Errorf("titleSimilarity(%q, %q) = %v, want match %v", tt.query, tt.title, got, tt.match)
+		}
+	}
+}
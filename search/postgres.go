@@ -0,0 +1,153 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+
+	"silic0n-wiki/database"
+)
+
+// PostgresIndex searches the articles table directly. Its search vector is a
+// generated column, so there is nothing to maintain on writes.
+type PostgresIndex struct{}
+
+func NewPostgresIndex() *PostgresIndex {
+	return &PostgresIndex{}
+}
+
+var headlineOptions = fmt.Sprintf(
+	`StartSel=%s, StopSel=%s, MaxWords=35, MinWords=15, MaxFragments=2, FragmentDelimiter=" … "`,
+	HighlightStart, HighlightStop,
+)
+
+func (p *PostgresIndex) Index(doc Document) error { return nil }
+func (p *PostgresIndex) Delete(id int) error      { return nil }
+func (p *PostgresIndex) Reset() error             { return nil }
+func (p *PostgresIndex) Close() error             { return nil }
+
+func (p *PostgresIndex) Count() (int, error) {
+	var n int
+	err := database.DB.QueryRow(`SELECT COUNT(*) FROM articles WHERE deleted_at IS NULL`).Scan(&n)
+	return n, err
+}
+
+// Query runs a full-text search over titles and content, best matches
+// first. The query uses web search syntax: quoted phrases, OR and
+// -excluded words. Titles containing the query as typed also match so
+// partially typed words still find something, as do titles that are close
+// by trigram similarity so misspellings are forgiven; those rank below
+// full-text matches.
+func (p *PostgresIndex) Query(q Query) (*Results, error) {
+	results := &Results{}
+
+	where, args := searchFilter(q.Text, q.Category, q.Tag)
+	err := database.DB.QueryRow(
+		`SELECT COUNT(*) `+searchFrom+where, args...,
+	).Scan(&results.Total)
+	if err != nil {
+		return nil, err
+	}
+
+	order := "rank DESC, word_similarity($1, a.title) DESC, a.title"
+	switch q.Sort {
+	case SortTitle:
+		order = "a.title"
+	case SortUpdated:
+		order = "a.updated_at DESC"
+	}
+
+	n := len(args)
+	rows, err := database.DB.Query(
+		`SELECT a.id, a.slug, a.title, a.last_edited_by, a.updated_at,
+		        COALESCE(c.name, ''), COALESCE(c.slug, ''),
+		        ts_headline('english', a.content, q, $`+fmt.Sprint(n+1)+`),
+		        ts_rank(a.search_vector, q) AS rank `+
+			searchFrom+where+
+			` ORDER BY `+order+
+			fmt.Sprintf(` LIMIT $%d OFFSET $%d`, n+2, n+3),
+		append(args, headlineOptions, q.Limit, q.Offset)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var h Hit
+		if err := rows.Scan(&h.ID, &h.Slug, &h.Title, &h.LastEditedBy, &h.UpdatedAt,
+			&h.CategoryName, &h.CategorySlug, &h.Snippet, &h.Score); err != nil {
+			return nil, err
+		}
+		h.Exact = h.Score > 0
+		results.Hits = append(results.Hits, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	where, args = searchFilter(q.Text, "", q.Tag)
+	results.Categories, err = searchFacets(
+		`SELECT c.slug, c.name, COUNT(*) `+searchFrom+where+
+			` AND c.id IS NOT NULL GROUP BY c.slug, c.name ORDER BY COUNT(*) DESC, c.name`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	where, args = searchFilter(q.Text, q.Category, "")
+	results.Tags, err = searchFacets(
+		`SELECT t.slug, MIN(t.name), COUNT(DISTINCT a.id) `+searchFrom+
+			` JOIN article_tags at ON at.article_id = a.id JOIN tags t ON t.id = at.tag_id`+where+
+			` GROUP BY t.slug ORDER BY COUNT(DISTINCT a.id) DESC, MIN(t.name) LIMIT 25`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+const searchFrom = `FROM websearch_to_tsquery('english', $1) q
+	CROSS JOIN articles a
+	LEFT JOIN categories c ON c.id = a.category_id`
+
+// searchFilter builds the WHERE clause shared by the search queries. The
+// query text is always $1 and the same text escaped for ILIKE is $2; empty
+// category and tag filters are left out.
+func searchFilter(text, category, tag string) (string, []interface{}) {
+	args := []interface{}{text, "%" + database.EscapeLike(text) + "%"}
+	clauses := []string{`a.deleted_at IS NULL`, `(a.search_vector @@ q
+		OR a.title ILIKE $2 ESCAPE '\' OR a.slug ILIKE $2 ESCAPE '\'
+		OR a.title % $1 OR $1 <% a.title)`}
+	if category != "" {
+		args = append(args, category)
+		clauses = append(clauses, fmt.Sprintf(`c.slug = $%d`, len(args)))
+	}
+	if tag != "" {
+		args = append(args, tag)
+		clauses = append(clauses, fmt.Sprintf(
+			`EXISTS (SELECT 1 FROM article_tags ft JOIN tags t2 ON t2.id = ft.tag_id
+			         WHERE ft.article_id = a.id AND t2.slug = $%d)`, len(args)))
+	}
+	return " WHERE " + strings.Join(clauses, " AND "), args
+}
+
+func searchFacets(query string, args ...interface{}) ([]Facet, error) {
+	rows, err := database.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var facets []Facet
+	for rows.Next() {
+		var f Facet
+		if err := rows.Scan(&f.Slug, &f.Name, &f.Count); err != nil {
+			return nil, err
+		}
+		facets = append(facets, f)
+	}
+	return facets, rows.Err()
+}
@@ -0,0 +1,245 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// word is one word of a text. term is its normalised form, or empty for
+// stop words, which are kept so snippets can be cut from the original.
+type word struct {
+	raw        string
+	term       string
+	start, end int
+}
+
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "but": true, "by": true, "for": true, "if": true, "in": true,
+	"into": true, "is": true, "it": true, "no": true, "not": true, "of": true,
+	"on": true, "or": true, "such": true, "that": true, "the": true,
+	"their": true, "then": true, "there": true, "these": true, "they": true,
+	"this": true, "to": true, "was": true, "will": true, "with": true,
+}
+
+// splitWords breaks text into runs of letters and digits.
+func splitWords(text string) []word {
+	var words []word
+	start := -1
+	for i, r := range text {
+		inWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if inWord && start < 0 {
+			start = i
+		} else if !inWord && start >= 0 {
+			words = append(words, newWord(text[start:i], start, i))
+			start = -1
+		}
+	}
+	if start >= 0 {
+		words = append(words, newWord(text[start:], start, len(text)))
+	}
+	return words
+}
+
+func newWord(raw string, start, end int) word {
+	return word{raw: raw, term: normalize(raw), start: start, end: end}
+}
+
+// normalize lowercases a word and strips common English plural endings so
+// "databases" finds "database". Stop words normalise to "".
+func normalize(raw string) string {
+	w := strings.ToLower(raw)
+	if stopWords[w] {
+		return ""
+	}
+	switch {
+	case len(w) > 4 && strings.HasSuffix(w, "ies"):
+		return w[:len(w)-3] + "y"
+	case len(w) > 3 && strings.HasSuffix(w, "s") &&
+		!strings.HasSuffix(w, "ss") && !strings.HasSuffix(w, "us") && !strings.HasSuffix(w, "is"):
+		return w[:len(w)-1]
+	}
+	return w
+}
+
+// parsedQuery is a query in web search syntax: every clause must match and
+// no excluded clause may. A clause matches when any of its alternatives
+// does, and an alternative is a sequence of terms that must appear
+// together, consecutively if it came from a quoted phrase.
+type parsedQuery struct {
+	clauses  []clause
+	excluded []clause
+}
+
+type clause struct {
+	alternatives [][]string
+	phrase       []bool
+}
+
+// parseQuery understands the same syntax as Postgres' websearch_to_tsquery:
+// "quoted phrases", OR between words and -excluded words.
+func parseQuery(text string) parsedQuery {
+	var q parsedQuery
+	var pendingOr bool
+
+	for _, tok := range tokenizeQuery(text) {
+		if !tok.quoted && strings.EqualFold(tok.text, "or") {
+			pendingOr = len(q.clauses) > 0
+			continue
+		}
+
+		var terms []string
+		for _, w := range splitWords(tok.text) {
+			if w.term != "" {
+				terms = append(terms, w.term)
+			}
+		}
+		if len(terms) == 0 {
+			continue
+		}
+
+		if tok.negated {
+			q.excluded = append(q.excluded, clause{
+				alternatives: [][]string{terms},
+				phrase:       []bool{tok.quoted},
+			})
+			pendingOr = false
+			continue
+		}
+
+		// An unquoted token with several words, such as "full-text", is a
+		// phrase too, as it is in websearch_to_tsquery.
+		phrase := tok.quoted || len(terms) > 1
+		if pendingOr {
+			last := &q.clauses[len(q.clauses)-1]
+			last.alternatives = append(last.alternatives, terms)
+			last.phrase = append(last.phrase, phrase)
+			pendingOr = false
+			continue
+		}
+		q.clauses = append(q.clauses, clause{
+			alternatives: [][]string{terms},
+			phrase:       []bool{phrase},
+		})
+	}
+	return q
+}
+
+type queryToken struct {
+	text    string
+	quoted  bool
+	negated bool
+}
+
+func tokenizeQuery(text string) []queryToken {
+	var tokens []queryToken
+	for len(text) > 0 {
+		text = strings.TrimLeftFunc(text, unicode.IsSpace)
+		if text == "" {
+			break
+		}
+
+		var tok queryToken
+		if text[0] == '-' {
+			tok.negated = true
+			text = text[1:]
+		}
+		if strings.HasPrefix(text, `"`) {
+			tok.quoted = true
+			text = text[1:]
+			end := strings.IndexByte(text, '"')
+			if end < 0 {
+				end = len(text)
+			}
+			tok.text = text[:end]
+			text = text[min(end+1, len(text)):]
+		} else {
+			end := strings.IndexFunc(text, unicode.IsSpace)
+			if end < 0 {
+				end = len(text)
+			}
+			tok.text = text[:end]
+			text = text[end:]
+		}
+		tokens = append(tokens, tok)
+	}
+	return tokens
+}
+
+func (q parsedQuery) matches(doc *indexedDoc) bool {
+	for _, c := range q.clauses {
+		if !c.matches(doc) {
+			return false
+		}
+	}
+	for _, c := range q.excluded {
+		if c.matches(doc) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c clause) matches(doc *indexedDoc) bool {
+	for i, terms := range c.alternatives {
+		if c.phrase[i] {
+			if containsPhrase(doc.title, terms) || containsPhrase(doc.body, terms) {
+				return true
+			}
+			continue
+		}
+		all := true
+		for _, t := range terms {
+			if _, ok := doc.freq[t]; !ok {
+				all = false
+				break
+			}
+		}
+		if all {
+			return true
+		}
+	}
+	return false
+}
+
+// containsPhrase reports whether terms appear consecutively in words,
+// ignoring stop words in between.
+func containsPhrase(words []word, terms []string) bool {
+	var seq []string
+	for _, w := range words {
+		if w.term != "" {
+			seq = append(seq, w.term)
+		}
+	}
+	for i := 0; i+len(terms) <= len(seq); i++ {
+		match := true
+		for j, t := range terms {
+			if seq[i+j] != t {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// positiveTerms returns every term the query looks for, for scoring and
+// highlighting.
+func (q parsedQuery) positiveTerms() []string {
+	var terms []string
+	seen := make(map[string]bool)
+	for _, c := range q.clauses {
+		for _, alt := range c.alternatives {
+			for _, t := range alt {
+				if !seen[t] {
+					seen[t] = true
+					terms = append(terms, t)
+				}
+			}
+		}
+	}
+	return terms
+}
@@ -0,0 +1,106 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		raw, want string
+	}{
+		{"Database", "database"},
+		{"databases", "database"},
+		{"libraries", "library"},
+		{"ties", "tie"},
+		{"class", "class"},
+		{"status", "status"},
+		{"analysis", "analysis"},
+		{"The", ""},
+		{"go", "go"},
+	}
+	for _, tt := range tests {
+		if got := normalize(tt.raw); got != tt.want {
+			t.Errorf("normalize(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestSplitWords(t *testing.T) {
+	got := splitWords("Go's full-text, über 2x")
+	want := []word{
+		{raw: "Go", term: "go", start: 0, end: 2},
+		{raw: "s", term: "s", start: 3, end: 4},
+		{raw: "full", term: "full", start: 5, end: 9},
+		{raw: "text", term: "text", start: 10, end: 14},
+		{raw: "über", term: "über", start: 16, end: 21},
+		{raw: "2x", term: "2x", start: 22, end: 24},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitWords =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want parsedQuery
+	}{
+		{"empty", "", parsedQuery{}},
+		{"stop words only", "the and of", parsedQuery{}},
+		{"words", "fast databases", parsedQuery{clauses: []clause{
+			{alternatives: [][]string{{"fast"}}, phrase: []bool{false}},
+			{alternatives: [][]string{{"database"}}, phrase: []bool{false}},
+		}}},
+		{"quoted phrase", `"full text" search`, parsedQuery{clauses: []clause{
+			{alternatives: [][]string{{"full", "text"}}, phrase: []bool{true}},
+			{alternatives: [][]string{{"search"}}, phrase: []bool{false}},
+		}}},
+		{"hyphenated word is a phrase", "full-text", parsedQuery{clauses: []clause{
+			{alternatives: [][]string{{"full", "text"}}, phrase: []bool{true}},
+		}}},
+		{"or", "cats or dogs", parsedQuery{clauses: []clause{
+			{alternatives: [][]string{{"cat"}, {"dog"}}, phrase: []bool{false, false}},
+		}}},
+		{"leading or is ignored", "or cats", parsedQuery{clauses: []clause{
+			{alternatives: [][]string{{"cat"}}, phrase: []bool{false}},
+		}}},
+		{"excluded", "cats -dogs", parsedQuery{
+			clauses:  []clause{{alternatives: [][]string{{"cat"}}, phrase: []bool{false}}},
+			excluded: []clause{{alternatives: [][]string{{"dog"}}, phrase: []bool{false}}},
+		}},
+		{"excluded phrase", `-"hot dog"`, parsedQuery{
+			excluded: []clause{{alternatives: [][]string{{"hot", "dog"}}, phrase: []bool{true}}},
+		}},
+		{"unterminated quote", `"open ended`, parsedQuery{clauses: []clause{
+			{alternatives: [][]string{{"open", "ended"}}, phrase: []bool{true}},
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseQuery(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseQuery(%q) =\n%+v\nwant\n%+v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContainsPhrase(t *testing.T) {
+	words := splitWords("The quick brown fox jumps over the lazy dog")
+	tests := []struct {
+		terms []string
+		want  bool
+	}{
+		{[]string{"quick", "brown"}, true},
+		{[]string{"brown", "quick"}, false},
+		{[]string{"over", "lazy"}, true},
+		{[]string{"lazy", "dog", "barks"}, false},
+		{[]string{"fox"}, true},
+	}
+	for _, tt := range tests {
+		if got := containsPhrase(words, tt.terms); got != tt.want {
+			t.Errorf("containsPhrase(%q) = %v, want %v", tt.terms, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+// Package search defines the article search index and its backends.
+//
+// Two backends are available, selected by search.backend in config.yaml:
+// "postgres" queries the articles table directly using its full-text and
+// trigram indexes, and "embedded" keeps an inverted index in memory that is
+// persisted to search.index_path.
+package search
+
+import (
+	"fmt"
+	"time"
+
+	"silic0n-wiki/config"
+)
+
+// Snippet highlight markers. Backends wrap matched terms in these so the
+// snippet can be HTML-escaped before the markers are turned into tags.
+const (
+	HighlightStart = "\uE000"
+	HighlightStop  = "\uE001"
+)
+
+// Sort orders accepted by Query.Sort.
+const (
+	SortRelevance = "relevance"
+	SortTitle     = "title"
+	SortUpdated   = "updated"
+)
+
+// Document is an article as stored in the index.
+type Document struct {
+	ID           int
+	Slug         string
+	Title        string
+	Content      string
+	CategorySlug string
+	CategoryName string
+	Tags         []Tag
+	LastEditedBy string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+type Tag struct {
+	Slug string
+	Name string
+}
+
+type Query struct {
+	Text     string
+	Category string // category slug, optional
+	Tag      string // tag slug, optional
+	Sort     string
+	Limit    int
+	Offset   int
+}
+
+type Hit struct {
+	ID           int
+	Slug         string
+	Title        string
+	CategorySlug string
+	CategoryName string
+	LastEditedBy string
+	UpdatedAt    time.Time
+	Snippet      string
+	Score        float64
+	// Exact is false for hits that did not match the query as a full-text
+	// search, only through a title containing it or resembling it.
+	Exact bool
+}
+
+// Facet is a category or tag with the number of matching articles in it.
+type Facet struct {
+	Slug  string
+	Name  string
+	Count int
+}
+
+type Results struct {
+	Hits       []Hit
+	Total      int
+	Categories []Facet
+	Tags       []Facet
+}
+
+// Index is a searchable collection of articles. Each facet in Results is
+// counted with the other filter applied but not its own, so picking a
+// category still shows every tag available within it.
+type Index interface {
+	Index(doc Document) error
+	Delete(id int) error
+	Query(q Query) (*Results, error)
+	// Count returns the number of indexed documents.
+	Count() (int, error)
+	// Reset removes every document, ahead of a full reindex.
+	Reset() error
+	Close() error
+}
+
+// Default is the index selected in the configuration, set by Open.
+var Default Index
+
+// Open creates the configured index and makes it the Default.
+func Open() error {
+	cfg := config.AppConfig.Search
+
+	var idx Index
+	var err error
+	switch cfg.Backend {
+	case "", "postgres":
+		idx = NewPostgresIndex()
+	case "embedded":
+		idx, err = OpenDiskIndex(cfg.IndexPath)
+	default:
+		err = fmt.Errorf("unknown search backend %q", cfg.Backend)
+	}
+	if err != nil {
+		return err
+	}
+
+	Default = idx
+	return nil
+}
+
+// Close closes the Default index.
+func Close() error {
+	if Default != nil {
+		return Default.Close()
+	}
+	return nil
+}
@@ -6,6 +6,12 @@ document.addEventListener('DOMContentLoaded', function() {
 
     let debounceTimer;
 
+    function escapeHTML(s) {
+        return s.replace(/[&<>"']/g, function(c) {
+            return {'&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;'}[c];
+        });
+    }
+
     searchInput.addEventListener('input', function() {
         const query = this.value.trim();
 
@@ -25,9 +31,9 @@ document.addEventListener('DOMContentLoaded', function() {
                         searchResults.innerHTML = '<div class="no-results">No articles found</div>';
                     } else {
                         searchResults.innerHTML = data.map(article =>
-                            '<a href="/wiki/' + article.slug + '" class="search-result-item">' +
+                            '<a href="' + escapeHTML(article.url) + '" class="search-result-item">' +
                             '<div class="search-result-content">' +
-                            '<span class="search-result-title">' + article.title + '</span>' +
+                            '<span class="search-result-title">' + escapeHTML(article.title) + '</span>' +
                             '<span class="search-result-description">' + article.description + '</span>' +
                             '</div>' +
                             '</a>'
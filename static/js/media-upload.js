@@ -74,6 +74,9 @@ document.addEventListener('DOMContentLoaded', function () {
 
         var formData = new FormData();
         formData.append('file', file);
+        if (zone.dataset.articleId) {
+            formData.append('article_id', zone.dataset.articleId);
+        }
 
         progressWrap.style.display = 'block';
         progressFill.style.width = '0%';
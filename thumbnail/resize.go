@@ -0,0 +1,99 @@
+package thumbnail
+
+import (
+	"image"
+	"image/draw"
+)
+
+// Resize scales img to width pixels wide, keeping its aspect ratio. It
+// averages every source pixel a destination pixel covers, which gives
+// smooth results when shrinking. Images are never enlarged.
+func Resize(img image.Image, width int) *image.RGBA {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	if width <= 0 || width > sw {
+		width = sw
+	}
+	height := (sh*width + sw/2) / sw
+	if height < 1 {
+		height = 1
+	}
+
+	// Work on premultiplied RGBA so transparent pixels do not bleed
+	// their colour into their neighbours.
+	src := image.NewRGBA(image.Rect(0, 0, sw, sh))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	if width == sw && height == sh {
+		return src
+	}
+
+	// Scale rows first into a float buffer, then columns.
+	xw := weights(sw, width)
+	tmp := make([]float32, width*sh*4)
+	for y := 0; y < sh; y++ {
+		row := src.Pix[y*src.Stride:]
+		for x, ws := range xw {
+			var c [4]float32
+			for _, w := range ws {
+				p := row[w.index*4:]
+				for i := range c {
+					c[i] += float32(p[i]) * w.weight
+				}
+			}
+			copy(tmp[(y*width+x)*4:], c[:])
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	yw := weights(sh, height)
+	for y, ws := range yw {
+		out := dst.Pix[y*dst.Stride:]
+		for x := 0; x < width; x++ {
+			var c [4]float32
+			for _, w := range ws {
+				p := tmp[(w.index*width+x)*4:]
+				for i := range c {
+					c[i] += p[i] * w.weight
+				}
+			}
+			for i := range c {
+				out[x*4+i] = clamp(c[i])
+			}
+		}
+	}
+	return dst
+}
+
+type weight struct {
+	index  int
+	weight float32
+}
+
+// weights returns, for each of the n destination pixels along an axis of
+// size src, the source pixels it covers and how much of each.
+func weights(src, n int) [][]weight {
+	scale := float64(src) / float64(n)
+	all := make([][]weight, n)
+	for i := range all {
+		start := float64(i) * scale
+		end := start + scale
+		for j := int(start); j < src && float64(j) < end; j++ {
+			lo := max(start, float64(j))
+			hi := min(end, float64(j+1))
+			if hi > lo {
+				all[i] = append(all[i], weight{j, float32((hi - lo) / scale)})
+			}
+		}
+	}
+	return all
+}
+
+func clamp(v float32) uint8 {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 255:
+		return 255
+	}
+	return uint8(v + 0.5)
+}
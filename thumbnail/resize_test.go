@@ -0,0 +1,83 @@
+package thumbnail
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestResizeSize(t *testing.T) {
+	tests := []struct {
+		name          string
+		sw, sh, width int
+		wantW, wantH  int
+	}{
+		{"halve", 100, 50, 50, 50, 25},
+		{"rounds height", 300, 100, 200, 200, 67},
+		{"never enlarges", 40, 30, 100, 40, 30},
+		{"zero keeps size", 40, 30, 0, 40, 30},
+		{"thin image keeps a row", 1000, 1, 10, 10, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Resize(image.NewRGBA(image.Rect(0, 0, tt.sw, tt.sh)), tt.width).Bounds()
+			if got.Dx() != tt.wantW || got.Dy() != tt.wantH {
+				t.Errorf("Resize(%dx%d, %d) = %dx%d, want %dx%d",
+					tt.sw, tt.sh, tt.width, got.Dx(), got.Dy(), tt.wantW, tt.wantH)
+			}
+		})
+	}
+}
+
+func TestResizeAverages(t *testing.T) {
+	// A black and white checkerboard shrinks to mid grey.
+	src := image.NewGray(image.Rect(0, 0, 8, 8))
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if (x+y)%2 == 0 {
+				src.SetGray(x, y, color.Gray{255})
+			}
+		}
+	}
+	dst := Resize(src, 2)
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 2; x++ {
+			if c := dst.RGBAAt(x, y); c.R < 126 || c.R > 129 || c.A != 255 {
+				t.Errorf("pixel %d,%d = %v, want mid grey", x, y, c)
+			}
+		}
+	}
+}
+
+func TestResizeTransparency(t *testing.T) {
+	// Fully transparent red pixels must not tint their opaque neighbours.
+	src := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			if x < 2 {
+				src.SetNRGBA(x, y, color.NRGBA{255, 0, 0, 0})
+			} else {
+				src.SetNRGBA(x, y, color.NRGBA{0, 0, 255, 255})
+			}
+		}
+	}
+	c := Resize(src, 1).RGBAAt(0, 0)
+	if c.R != 0 || c.A < 126 || c.A > 129 {
+		t.Errorf("pixel = %v, want half transparent blue", c)
+	}
+}
+
+func TestWeights(t *testing.T) {
+	for _, tt := range []struct{ src, n int }{{10, 3}, {7, 7}, {1280, 320}, {3, 2}} {
+		for i, ws := range weights(tt.src, tt.n) {
+			var sum float64
+			for _, w := range ws {
+				sum += float64(w.weight)
+			}
+			if math.Abs(sum-1) > 1e-5 {
+				t.Errorf("weights(%d, %d)[%d] sum to %v, want 1", tt.src, tt.n, i, sum)
+			}
+		}
+	}
+}
@@ -0,0 +1,234 @@
+// Package thumbnail creates resized copies of uploaded images so pages can
+// serve an image no larger than it is displayed. It handles JPEG, PNG and
+// GIF using only the standard library.
+package thumbnail
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Widths are the variant widths generated for each image, smallest first.
+var Widths = []int{320, 640, 1280}
+
+// jpegQuality is used when re-encoding JPEG variants.
+const jpegQuality = 85
+
+// ErrAnimated is returned for animated GIFs, which are served as uploaded
+// since resizing would drop every frame but the first.
+var ErrAnimated = errors.New("thumbnail: animated images are not resized")
+
+// ErrUnsupported is returned for media types that cannot be resized.
+var ErrUnsupported = errors.New("thumbnail: unsupported image type")
+
+// ErrTooLarge is returned for images with more pixels than the caller
+// allows to be decoded.
+var ErrTooLarge = errors.New("thumbnail: image has too many pixels")
+
+// Supported reports whether images of mimeType can be resized.
+func Supported(mimeType string) bool {
+	switch mimeType {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// OutputType is the content type of variants made from mimeType. GIF
+// variants are stored as PNG so they keep full colour and transparency.
+func OutputType(mimeType string) string {
+	if mimeType == "image/gif" {
+		return "image/png"
+	}
+	return mimeType
+}
+
+// Variants returns the widths that have a variant for an image of the
+// given type and width: every width in Widths narrower than the original.
+func Variants(mimeType string, width int) []int {
+	if !Supported(mimeType) {
+		return nil
+	}
+	var widths []int
+	for _, w := range Widths {
+		if w < width {
+			widths = append(widths, w)
+		}
+	}
+	return widths
+}
+
+// Fit returns the smallest variant width of at least want, or 0 if the
+// original should be served instead.
+func Fit(mimeType string, width, want int) int {
+	for _, w := range Variants(mimeType, width) {
+		if w >= want {
+			return w
+		}
+	}
+	return 0
+}
+
+// Path is where the variant of filename at width is cached under dir. Like
+// the originals, variants are spread over subdirectories named after the
+// start of the file name.
+func Path(dir string, width int, filename string) string {
+	if len(filename) < 5 {
+		return filepath.Join(dir, strconv.Itoa(width), filename)
+	}
+	return filepath.Join(dir, strconv.Itoa(width), filename[:2], filename[2:4], filename)
+}
+
+// MoveUnsharded moves the variants of filename cached directly under
+// dir/{width}/, as they were before Path used subdirectories.
+func MoveUnsharded(dir, filename string) error {
+	for _, w := range Widths {
+		old := filepath.Join(dir, strconv.Itoa(w), filename)
+		for _, suffix := range []string{"", animatedMarker("")} {
+			if _, err := os.Stat(old + suffix); err != nil {
+				continue
+			}
+			dst := Path(dir, w, filename) + suffix
+			if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+				return err
+			}
+			if err := os.Rename(old+suffix, dst); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Dimensions reads the pixel size of an image file without decoding it.
+func Dimensions(path string) (width, height int, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, 0, err
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// Create writes a copy of the image at src scaled to width, keeping its
+// aspect ratio, to dst. The file is written to a temporary name first so
+// concurrent requests never see a partial variant. Images of more than
+// maxPixels pixels are not decoded and return ErrTooLarge.
+//
+// Animated GIFs leave a marker next to dst instead, so later calls return
+// ErrAnimated without decoding every frame again.
+func Create(src, dst, mimeType string, width int, maxPixels int64) error {
+	if !Supported(mimeType) {
+		return ErrUnsupported
+	}
+	if _, err := os.Stat(animatedMarker(dst)); err == nil {
+		return ErrAnimated
+	}
+	w, h, err := Dimensions(src)
+	if err != nil {
+		return err
+	}
+	if int64(w)*int64(h) > maxPixels {
+		return ErrTooLarge
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	img, err := decode(src, mimeType)
+	if err == ErrAnimated {
+		if f, err := os.Create(animatedMarker(dst)); err == nil {
+			f.Close()
+		}
+		return ErrAnimated
+	}
+	if err != nil {
+		return err
+	}
+
+	resized := Resize(img, width)
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".thumb-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	switch OutputType(mimeType) {
+	case "image/jpeg":
+		err = jpeg.Encode(tmp, resized, &jpeg.Options{Quality: jpegQuality})
+	default:
+		err = png.Encode(tmp, resized)
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+// Remove deletes every cached variant of filename under dir.
+func Remove(dir, filename string) {
+	for _, w := range Widths {
+		path := Path(dir, w, filename)
+		os.Remove(path)
+		os.Remove(animatedMarker(path))
+	}
+}
+
+func animatedMarker(path string) string {
+	return path + ".animated"
+}
+
+// CreateAll writes every variant of the image at src into dir and returns
+// the widths written.
+func CreateAll(src, dir, filename, mimeType string, width int, maxPixels int64) ([]int, error) {
+	var created []int
+	for _, w := range Variants(mimeType, width) {
+		if err := Create(src, Path(dir, w, filename), mimeType, w, maxPixels); err != nil {
+			return created, err
+		}
+		created = append(created, w)
+	}
+	return created, nil
+}
+
+func decode(path, mimeType string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if mimeType == "image/gif" {
+		g, err := gif.DecodeAll(f)
+		if err != nil {
+			return nil, err
+		}
+		if len(g.Image) > 1 {
+			return nil, ErrAnimated
+		}
+		// Draw the frame onto the logical screen, as a viewer would.
+		bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+		canvas := image.NewRGBA(bounds)
+		draw.Draw(canvas, g.Image[0].Bounds(), g.Image[0], g.Image[0].Bounds().Min, draw.Over)
+		return canvas, nil
+	}
+
+	img, _, err := image.Decode(f)
+	return img, err
+}
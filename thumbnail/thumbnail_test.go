@@ -0,0 +1,242 @@
+package thumbnail
+
+import (
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const noPixelLimit = 1 << 40
+
+func TestVariants(t *testing.T) {
+	tests := []struct {
+		mimeType string
+		width    int
+		want     []int
+	}{
+		{"image/jpeg", 2000, []int{320, 640, 1280}},
+		{"image/png", 1280, []int{320, 640}},
+		{"image/gif", 321, []int{320}},
+		{"image/jpeg", 320, nil},
+		{"image/jpeg", 0, nil},
+		{"image/webp", 2000, nil},
+		{"video/mp4", 2000, nil},
+	}
+	for _, tt := range tests {
+		if got := Variants(tt.mimeType, tt.width); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Variants(%q, %d) = %v, want %v", tt.mimeType, tt.width, got, tt.want)
+		}
+	}
+}
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		width, want, fit int
+	}{
+		{2000, 100, 320},
+		{2000, 320, 320},
+		{2000, 321, 640},
+		{2000, 1280, 1280},
+		{2000, 1500, 0},
+		{500, 400, 0},
+		{100, 50, 0},
+	}
+	for _, tt := range tests {
+		if got := Fit("image/jpeg", tt.width, tt.want); got != tt.fit {
+			t.Errorf("Fit(%d, %d) = %d, want %d", tt.width, tt.want, got, tt.fit)
+		}
+	}
+}
+
+func writeImage(t *testing.T, path, mimeType string, w, h int) {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 100, 255})
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	switch mimeType {
+	case "image/jpeg":
+		err = jpeg.Encode(f, img, nil)
+	case "image/png":
+		err = png.Encode(f, img)
+	case "image/gif":
+		err = gif.Encode(f, img, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	for _, tt := range []struct {
+		mimeType string
+		decode   func(*os.File) (image.Image, error)
+	}{
+		{"image/jpeg", func(f *os.File) (image.Image, error) { return jpeg.Decode(f) }},
+		{"image/png", func(f *os.File) (image.Image, error) { return png.Decode(f) }},
+		// GIF variants are stored as PNG.
+		{"image/gif", func(f *os.File) (image.Image, error) { return png.Decode(f) }},
+	} {
+		t.Run(tt.mimeType, func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "src")
+			writeImage(t, src, tt.mimeType, 200, 100)
+
+			dst := Path(filepath.Join(dir, "thumbs"), 50, "abcdef.img")
+			if err := Create(src, dst, tt.mimeType, 50, noPixelLimit); err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.Open(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			img, err := tt.decode(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if b := img.Bounds(); b.Dx() != 50 || b.Dy() != 25 {
+				t.Errorf("variant is %dx%d, want 50x25", b.Dx(), b.Dy())
+			}
+		})
+	}
+}
+
+func TestCreateErrors(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.png")
+	writeImage(t, src, "image/png", 100, 100)
+
+	if err := Create(src, filepath.Join(dir, "a"), "image/webp", 50, noPixelLimit); err != ErrUnsupported {
+		t.Errorf("webp: err = %v, want ErrUnsupported", err)
+	}
+	if err := Create(src, filepath.Join(dir, "b"), "image/png", 50, 100*100-1); err != ErrTooLarge {
+		t.Errorf("over the pixel limit: err = %v, want ErrTooLarge", err)
+	}
+	if err := Create(src, filepath.Join(dir, "c"), "image/png", 50, 100*100); err != nil {
+		t.Errorf("at the pixel limit: err = %v", err)
+	}
+	if err := Create(filepath.Join(dir, "missing"), filepath.Join(dir, "d"), "image/png", 50, noPixelLimit); !os.IsNotExist(err) {
+		t.Errorf("missing source: err = %v, want not exist", err)
+	}
+}
+
+func TestCreateAnimated(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "anim.gif")
+	frame := image.NewPaletted(image.Rect(0, 0, 100, 100), palette.Plan9)
+	f, err := os.Create(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = gif.EncodeAll(f, &gif.GIF{Image: []*image.Paletted{frame, frame}, Delay: []int{10, 10}})
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(dir, "thumbs", "anim.gif")
+	if err := Create(src, dst, "image/gif", 50, noPixelLimit); err != ErrAnimated {
+		t.Fatalf("err = %v, want ErrAnimated", err)
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Error("a variant was written for an animated GIF")
+	}
+	if _, err := os.Stat(animatedMarker(dst)); err != nil {
+		t.Errorf("no marker left: %v", err)
+	}
+
+	// The marker answers later calls without decoding the file again.
+	os.Remove(src)
+	if err := Create(src, dst, "image/gif", 50, noPixelLimit); err != ErrAnimated {
+		t.Errorf("second call: err = %v, want ErrAnimated", err)
+	}
+}
+
+func TestCreateAllAndRemove(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.png")
+	writeImage(t, src, "image/png", 700, 10)
+	thumbs := filepath.Join(dir, "thumbs")
+
+	created, err := CreateAll(src, thumbs, "abcdef.png", "image/png", 700, noPixelLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{320, 640}; !reflect.DeepEqual(created, want) {
+		t.Errorf("CreateAll = %v, want %v", created, want)
+	}
+	for _, w := range created {
+		if _, err := os.Stat(Path(thumbs, w, "abcdef.png")); err != nil {
+			t.Errorf("variant %d: %v", w, err)
+		}
+	}
+
+	Remove(thumbs, "abcdef.png")
+	for _, w := range Widths {
+		if _, err := os.Stat(Path(thumbs, w, "abcdef.png")); !os.IsNotExist(err) {
+			t.Errorf("variant %d was not removed", w)
+		}
+	}
+}
+
+func TestPath(t *testing.T) {
+	tests := []struct {
+		filename, want string
+	}{
+		{"abcdef.png", filepath.Join("thumbs", "320", "ab", "cd", "abcdef.png")},
+		{"a.gi", filepath.Join("thumbs", "320", "a.gi")},
+	}
+	for _, tt := range tests {
+		if got := Path("thumbs", 320, tt.filename); got != tt.want {
+			t.Errorf("Path(%q) = %q, want %q", tt.filename, got, tt.want)
+		}
+	}
+}
+
+func TestMoveUnsharded(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, "320", "abcdef.png")
+	oldMarker := animatedMarker(filepath.Join(dir, "640", "abcdef.png"))
+	for _, path := range []string{old, oldMarker} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := MoveUnsharded(dir, "abcdef.png"); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{Path(dir, 320, "abcdef.png"), animatedMarker(Path(dir, 640, "abcdef.png"))} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("not moved into place: %v", err)
+		}
+	}
+	for _, path := range []string{old, oldMarker} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s is still there", path)
+		}
+	}
+
+	// Running it again finds nothing to move.
+	if err := MoveUnsharded(dir, "abcdef.png"); err != nil {
+		t.Error(err)
+	}
+}